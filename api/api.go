@@ -2,9 +2,9 @@ package api
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"image"
+	"image/color"
 	"image/png"
 	"log"
 	"math"
@@ -14,23 +14,22 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
-	"time"
 
 	"github.com/disintegration/imaging"
 	"github.com/fogleman/gg"
 	"github.com/gin-gonic/gin"
 	"github.com/hoshinonyaruko/snake-in-im/config"
+	"github.com/hoshinonyaruko/snake-in-im/food"
+	"github.com/hoshinonyaruko/snake-in-im/level"
 	"github.com/hoshinonyaruko/snake-in-im/memimg"
+	"github.com/hoshinonyaruko/snake-in-im/repository"
 	"github.com/hoshinonyaruko/snake-in-im/snake"
 	"github.com/hoshinonyaruko/snake-in-im/sqlite"
 	"github.com/hoshinonyaruko/snake-in-im/structs"
+	"github.com/hoshinonyaruko/snake-in-im/theme"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// 全局缓存
-var drawingCache sync.Map
-
 func InitDB() *sql.DB {
 	db, err := sql.Open("sqlite3", "game.db")
 	if err != nil {
@@ -42,7 +41,15 @@ func InitDB() *sql.DB {
 	return db
 }
 
-func UpdateDirection(db *sql.DB) gin.HandlerFunc {
+// InitRepository 根据配置创建游戏存储，"memory"为纯内存存储，其余使用game.db
+func InitRepository(storage string) repository.GameRepository {
+	if storage == "memory" {
+		return repository.NewMemory()
+	}
+	return sqlite.NewRepository(InitDB())
+}
+
+func UpdateDirection(repo repository.GameRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		groupID := c.Query("groupid")
 		openID := c.Query("openid")
@@ -54,8 +61,12 @@ func UpdateDirection(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		// 同一群组的请求依次执行
+		unlock := groupLocks.Lock(groupID)
+		defer unlock()
+
 		// 更新蛇的方向
-		if err := updateSnakeDirection(db, groupID, openID, newDirection); err != nil {
+		if err := updateSnakeDirection(repo, groupID, openID, newDirection); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update direction"})
 			return
 		}
@@ -65,7 +76,7 @@ func UpdateDirection(db *sql.DB) gin.HandlerFunc {
 	}
 }
 
-func updateSnakeDirection(db *sql.DB, groupID, openID, newDirection string) error {
+func updateSnakeDirection(repo repository.GameRepository, groupID, openID, newDirection string) error {
 	// 定义合法的方向集合
 	validDirections := map[string]bool{
 		"up":    true,
@@ -80,23 +91,17 @@ func updateSnakeDirection(db *sql.DB, groupID, openID, newDirection string) erro
 	}
 
 	// 执行更新操作
-	result, err := db.Exec("UPDATE Snakes SET Direction = ? WHERE GroupID = ? AND OpenID = ?", newDirection, groupID, openID)
-	if err != nil {
-		return err
-	}
-
-	// 确认是否确实更新了某条记录
-	if count, err := result.RowsAffected(); err != nil || count == 0 {
-		if err != nil {
-			return err
+	if err := repo.SetDirection(groupID, openID, newDirection); err != nil {
+		if err == repository.ErrNotFound {
+			return fmt.Errorf("no snake found with the specified groupID and openID")
 		}
-		return fmt.Errorf("no snake found with the specified groupID and openID, or the direction is already '%s'", newDirection)
+		return err
 	}
 
 	return nil
 }
 
-func RenderMapHandler(db *sql.DB) gin.HandlerFunc {
+func RenderMapHandler(repo repository.GameRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		groupID := c.Query("groupid")
 		openID := c.Query("openid")
@@ -106,6 +111,134 @@ func RenderMapHandler(db *sql.DB) gin.HandlerFunc {
 		refreshInterval, _ := strconv.Atoi(c.DefaultQuery("refresh_interval", "0"))
 		foodName := c.Query("foodname")
 		newDirection := c.Query("direction")
+		seed, _ := strconv.ParseInt(c.DefaultQuery("seed", "0"), 10, 64)
+		// 地图边缘 wrap solid bounce，只在创建地图时生效
+		edges, err := snake.ParseEdgeMode(c.DefaultQuery("edges", config.GetConfigValue("edges").(string)))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// 只有管理员可以放置的食物，例如活动用的金苹果
+		if err := checkFoodAllowed(c, foodName, openID); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		// 关卡地图 只在创建地图时生效，关卡的大小替换width和height
+		// 关卡指定了边缘模式时，请求中没有edges参数则使用关卡的边缘模式
+		var gameLevel *level.Level
+		if name := c.DefaultQuery("level", config.GetConfigValue("level").(string)); name != "" {
+			l, ok := level.Get(name)
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported level %q", name)})
+				return
+			}
+			gameLevel = l
+			if l.Edges != "" && c.Query("edges") == "" {
+				edges = l.Edges
+			}
+		}
+		// 群组背景 avatar:<openid> image:<name> color:#rrggbb
+		background := c.Query("background")
+		if background != "" {
+			if _, _, err := parseBackground(background); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		// 玩家昵称，显示在排行榜上
+		nickname := c.Query("nickname")
+		// 排行榜位置 none side top，未指定时使用配置
+		scoreboard := c.DefaultQuery("scoreboard", config.GetConfigValue("scoreboard").(string))
+		if !validScoreboard(scoreboard) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported scoreboard %q", scoreboard)})
+			return
+		}
+		// 输出方式 image text both，文字地图用于不能发送图片的渠道
+		mode := c.DefaultQuery("mode", config.GetConfigValue("rendermode").(string))
+		if !validRenderMode(mode) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported mode %q", mode)})
+			return
+		}
+		// 渲染参数 未指定时使用配置
+		opts := RenderOptions{
+			Direction:  newDirection,
+			Highlight:  config.GetConfigValue("highlightviewer").(bool),
+			Scoreboard: scoreboard,
+			Format:     c.Query("format"),
+			Quality:    config.GetConfigValue("imagequality").(int),
+			MaxBytes:   config.GetConfigValue("maximagebytes").(int),
+			TextStyle:  c.DefaultQuery("text_style", config.GetConfigValue("textstyle").(string)),
+			SnakeStyle: c.DefaultQuery("style", config.GetConfigValue("snakestyle").(string)),
+			AvatarHead: config.GetConfigValue("avatarhead").(bool),
+		}
+		if !validSnakeStyle(opts.SnakeStyle) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported style %q", opts.SnakeStyle)})
+			return
+		}
+		if avatarHead := c.Query("avatar_head"); avatarHead != "" {
+			opts.AvatarHead = avatarHead == "1" || avatarHead == "true"
+		}
+		if opts.Format == "" {
+			opts.Format = config.GetConfigValue("imageformat").(string)
+		}
+		if quality, err := strconv.Atoi(c.Query("quality")); err == nil {
+			opts.Quality = quality
+		}
+		if maxBytes, err := strconv.Atoi(c.Query("max_bytes")); err == nil && maxBytes > 0 {
+			opts.MaxBytes = maxBytes
+		}
+		if _, err := lookupImageFormat(opts.Format); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		glyphs, err := lookupTextGlyphs(opts.TextStyle)
+		if err != nil && mode != renderModeImage {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// 观看者视角 突出显示自己的蛇，大地图只渲染视口内的区域
+		if highlight := c.Query("highlight"); highlight != "" {
+			opts.Highlight = highlight == "1" || highlight == "true"
+		}
+		opts.viewport, err = viewportFromQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// GIF回放 渲染本次经过的每一次刷新
+		wantGIF := c.Query("gif") == "1" || c.Query("gif") == "true"
+		opts.FrameDelay, err = strconv.Atoi(c.Query("frame_delay"))
+		if err != nil || opts.FrameDelay <= 0 {
+			opts.FrameDelay = config.GetConfigValue("gifframedelay").(int)
+		}
+		maxFrames, err := strconv.Atoi(c.Query("max_frames"))
+		if err != nil || maxFrames <= 0 || maxFrames > config.GetConfigValue("gifmaxframes").(int) {
+			maxFrames = config.GetConfigValue("gifmaxframes").(int)
+		}
+		// 渲染器 renderer只用于本次请求，group_renderer修改群组的默认渲染器
+		renderer := c.Query("renderer")
+		defaultRenderer := c.Query("group_renderer")
+		for _, name := range []string{renderer, defaultRenderer} {
+			if name == "" {
+				continue
+			}
+			if _, err := lookupRenderer(name); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		// 主题 theme只用于本次请求，group_theme修改群组的主题
+		themeName := c.Query("theme")
+		defaultTheme := c.Query("group_theme")
+		for _, name := range []string{themeName, defaultTheme} {
+			if name == "" {
+				continue
+			}
+			if _, ok := theme.Get(name); !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported theme %q", name)})
+				return
+			}
+		}
 
 		if avatarUrl != "" {
 			// Process and save the avatar
@@ -114,41 +247,138 @@ func RenderMapHandler(db *sql.DB) gin.HandlerFunc {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to process avatar"})
 				return
 			}
+			// 头像变化后使用该头像的背景需要重新绘制
+			InvalidateAvatarBackground(openID)
 		}
 
+		// 同一群组的请求依次执行，避免互相覆盖对方的更新
+		unlock := groupLocks.Lock(groupID)
+		defer unlock()
+
 		// 获取&创建当前群游戏地图
-		gameMap, err := getOrCreateGameMap(db, groupID, width, height, refreshInterval)
+		gameMap, err := getOrCreateGameMap(repo, groupID, openID, width, height, refreshInterval, seed, edges, gameLevel)
 		if err != nil {
 			fmt.Printf("err getOrCreateGameMap :%v\n", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to fetch or create game map"})
 			return
 		}
 
-		// 贪食蛇刷新并接收被吃掉的食物位置
-		eatenPositions, err := snake.UpdateGameMapIfNeeded(gameMap, openID)
+		// 更换群组背景，对所有玩家生效
+		if background != "" {
+			gameMap.Background = background
+		}
+		// 旧版本创建的游戏没有保存背景，与新地图一样使用请求者的头像并保存
+		if gameMap.Background == "" {
+			gameMap.Background = backgroundAvatar + ":" + openID
+		}
+
+		// 更换群组的默认渲染器
+		if defaultRenderer != "" {
+			gameMap.Renderer = defaultRenderer
+		}
+		renderer = groupRenderer(renderer, gameMap)
+
+		// 更换群组的主题，对所有玩家生效
+		if defaultTheme != "" {
+			gameMap.Theme = defaultTheme
+		}
+		opts.Theme = groupTheme(themeName, gameMap)
+
+		// 记录玩家昵称
+		if nickname != "" {
+			if gameMap.Nicknames == nil {
+				gameMap.Nicknames = make(map[string]string)
+			}
+			gameMap.Nicknames[openID] = nickname
+		}
+
+		// 贪食蛇刷新并接收被吃掉的食物位置和被淘汰的蛇
+		var recorder *replayRecorder
+		var onTick func(game *structs.Game)
+		if wantGIF || renderer == rendererGIF {
+			recorder = newReplayRecorder(maxFrames)
+			onTick = recorder.record
+		}
+		result, err := snake.DefaultEngine.UpdateEach(gameMap, openID, onTick)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update game map"})
 			return
 		}
 
-		// 食物刷新
-		if foodName != "" {
+		// 食物刷新 random按稀有度随机选择，地图已满时不再放置
+		if foodName != "" && snake.HasFreeCell(&gameMap.Map) {
+			if foodName == food.RandomName {
+				foodName = snake.RandomFood(gameMap).Name
+			}
 			snake.AddFoodToGameMap(gameMap, foodName)
 		}
 
-		// 绘图
-		renderImageAndSave(&gameMap.Map, groupID, openID, newDirection) // Render the map and save as an image
+		if recorder != nil {
+			opts.Frames = recorder.frames
+		}
+
+		// 排行榜需要包括历史上的击杀数
+		if scoreboard != scoreboardNone {
+			deaths, err := repo.Deaths(groupID, 0)
+			if err != nil {
+				log.Printf("Failed to load deaths for groupID %s: %v", groupID, err)
+			}
+			opts.Ranking = buildGameState(gameMap, append(deaths, result.Deaths...)).Snakes
+		}
+
+		// 在JSON中添加eatenPositions和淘汰事件
+		response := gin.H{"eaten_food_positions": result.EatenFood, "deaths": result.Deaths}
+
+		// 绘图 失败时不返回image_url，只请求图片时返回500，游戏仍然会保存
+		status := http.StatusOK
+		var written []string // 本次写入的渲染结果，清理时保留
+		if mode != renderModeText {
+			imageName, contentType, err := renderAndSave(renderer, gameMap, openID, opts) // Render the map and save as an image
+			if err != nil {
+				log.Printf("Failed to render map for groupID %s: %v", groupID, err)
+				response["error"] = fmt.Sprintf("Unable to render map: %v", err)
+				if mode == renderModeImage {
+					status = http.StatusInternalServerError
+				}
+			} else {
+				written = append(written, imageName)
+				response["image_url"] = fmt.Sprintf("http://%s%s", config.GetConfigValue("selfpath").(string), imageName)
+				response["content_type"] = contentType
+			}
+		}
+
+		// 文字地图
+		if mode != renderModeImage {
+			response["text"], response["legend"] = renderText(gameMap, opts.view(openID), glyphs)
+		}
+
+		if wantGIF {
+			gifName, _, err := renderAndSave(rendererGIF, gameMap, openID, opts)
+			if err != nil {
+				log.Printf("Failed to render gif for groupID %s: %v", groupID, err)
+				if _, failed := response["error"]; !failed {
+					response["error"] = fmt.Sprintf("Unable to render gif: %v", err)
+				}
+			} else {
+				written = append(written, gifName)
+				response["gif_url"] = fmt.Sprintf("http://%s%s", config.GetConfigValue("selfpath").(string), gifName)
+			}
+		}
 
-		imageUrl := fmt.Sprintf("http://%s/static/%s.jpg", config.GetConfigValue("selfpath").(string), groupID)
-		// 在JSON中添加eatenPositions
-		c.JSON(http.StatusOK, gin.H{"image_url": imageUrl, "eaten_food_positions": eatenPositions})
+		// 清理旧的渲染结果
+		if err := pruneRenders(groupID, config.GetConfigValue("renderretention").(int), written...); err != nil {
+			log.Printf("Failed to prune renders for groupID %s: %v", groupID, err)
+		}
+		c.JSON(status, response)
 
 		// 持久化
-		sqlite.UpdateGameMapInDB(db, gameMap)
+		if err := repo.Save(gameMap, result.Deaths); err != nil {
+			log.Printf("Failed to save game map for groupID %s: %v", groupID, err)
+		}
 	}
 }
 
-func DeleteMapHandler(db *sql.DB) gin.HandlerFunc {
+func DeleteMapHandler(repo repository.GameRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		groupID := c.Query("groupid")
 
@@ -158,8 +388,12 @@ func DeleteMapHandler(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Call the deleteGameMap function to remove the map
-		err := deleteGameMap(db, groupID)
+		// Serialize with other requests for the same group
+		unlock := groupLocks.Lock(groupID)
+		defer unlock()
+
+		// Remove the map and everything attached to it
+		err := repo.Delete(groupID)
 		if err != nil {
 			// Log the error and return an appropriate message
 			log.Printf("Failed to delete game map for groupID %s: %v", groupID, err)
@@ -167,277 +401,215 @@ func DeleteMapHandler(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		// Rendered images of the deleted map are no longer needed
+		if err := removeRenders(groupID); err != nil {
+			log.Printf("Failed to remove renders for groupID %s: %v", groupID, err)
+		}
+
 		// If everything goes well, return a success message
 		c.JSON(http.StatusOK, gin.H{"message": "Game map successfully deleted"})
 	}
 }
 
-func getOrCreateGameMap(db *sql.DB, groupID string, width, height, refreshInterval int) (*structs.Game, error) {
-	var game structs.Game
+// DeathsHandler 返回群组的淘汰事件，after_tick用于只获取新的事件
+func DeathsHandler(repo repository.GameRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		groupID := c.Query("groupid")
+		afterTick, _ := strconv.ParseInt(c.DefaultQuery("after_tick", "0"), 10, 64)
+
+		if groupID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "groupID is required"})
+			return
+		}
+
+		deaths, err := repo.Deaths(groupID, afterTick)
+		if err != nil {
+			log.Printf("Failed to load deaths for groupID %s: %v", groupID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to load deaths"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"deaths": deaths})
+	}
+}
 
+func getOrCreateGameMap(repo repository.GameRepository, groupID, openID string, width, height, refreshInterval int, seed int64, edges string, gameLevel *level.Level) (*structs.Game, error) {
 	// Check and try to get the existing game map
-	var lastRefresh time.Time
-	err := db.QueryRow("SELECT GroupID, MapWidth, MapHeight, LastRefresh, RefreshInterval FROM Games WHERE GroupID = ?", groupID).Scan(
-		&game.GroupID, &game.Map.Width, &game.Map.Height, &lastRefresh, &game.RefreshInterval,
-	)
-	if err != nil && err != sql.ErrNoRows {
+	game, err := repo.Load(groupID)
+	if err != nil && err != repository.ErrNotFound {
 		return nil, err
 	}
-	game.LastRefresh = lastRefresh.Unix() // 转换为 Unix 时间戳
 
-	if err == sql.ErrNoRows {
+	if err == repository.ErrNotFound {
 		// Game map does not exist, create a new one
 		if refreshInterval == 0 {
 			refreshInterval = 3600 // Default refresh interval to one hour if not specified
 		}
+		game = &structs.Game{}
 		game.RefreshInterval = refreshInterval
 		game.GroupID = groupID
 		game.Map.Width = width
 		game.Map.Height = height
-		game.LastRefresh = time.Now().Unix()
-
-		// Insert a new game record
-		_, err = db.Exec("INSERT INTO Games (GroupID, MapWidth, MapHeight, LastRefresh, RefreshInterval) VALUES (?, ?, ?, ?, ?)",
-			groupID, width, height, game.LastRefresh, refreshInterval)
-		if err != nil {
-			return nil, err
+		game.Map.Edges = edges
+		game.LastRefresh = snake.DefaultEngine.Clock.Now().Unix()
+		// 未指定种子时随机生成，指定种子可以复现对局
+		if seed == 0 {
+			seed = snake.DefaultEngine.NewSeed()
 		}
+		game.Seed = seed
+		// 默认使用创建者的头像作为整个群组的背景
+		game.Background = backgroundAvatar + ":" + openID
+		game.Nicknames = make(map[string]string)
 
 		// Initialize empty snakes map and food position
 		game.Map.Snakes = make(map[string]structs.Snake)
-		// 初始化食物位置
-		game.Map.Food = []structs.Position{snake.GenerateRandomPositionWithAvatar(game.Map.Width, game.Map.Height, "food_small.png")}
-
-	} else {
-		// Load snakes
-		rows, err := db.Query("SELECT OpenID, Positions, Direction FROM Snakes WHERE GroupID = ?", groupID)
-		if err != nil {
-			return nil, err
-		}
-		defer rows.Close()
-
-		game.Map.Snakes = make(map[string]structs.Snake)
-		var posData string
-		for rows.Next() {
-			var snake structs.Snake
-			// 注意，我们不再从数据库读取Avatar，因为每个Position已经包含Avatar
-			if err := rows.Scan(&snake.OpenID, &posData, &snake.Direction); err != nil {
-				return nil, err
-			}
-			// 反序列化Position数据，其中每个Position包含了Avatar信息
-			if err := json.Unmarshal([]byte(posData), &snake.Positions); err != nil {
-				return nil, err
+		if gameLevel != nil {
+			// 关卡决定地图大小、墙和初始食物，关卡没有食物时随机放置一个
+			gameLevel.Apply(&game.Map)
+			game.Map.Edges = edges
+			game.Level = gameLevel.Name
+			if len(game.Map.Food) == 0 {
+				snake.AddFoodToGameMap(game, "food")
 			}
-			game.Map.Snakes[snake.OpenID] = snake
+		} else {
+			// 初始化食物位置
+			pos := snake.GenerateRandomPositionWithAvatar(snake.Rand(game, "food"), game.Map.Width, game.Map.Height, "food_small.png")
+			game.Map.Food = []structs.Position{food.Lookup(food.DefaultName).Position(pos.X, pos.Y)}
 		}
+		// 初始食物也写入输入记录，回放从空白地图开始
+		game.Inputs = nil
+		snake.RecordFood(game)
 
-		// Load food position
-		var foodPositions []structs.Position
-		rows, err = db.Query("SELECT Position FROM Foods WHERE GroupID = ?", game.GroupID)
-		if err != nil {
+		// Insert a new game record
+		if err := repo.Create(game); err != nil {
 			return nil, err
 		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var posData string
-			if err := rows.Scan(&posData); err != nil {
-				return nil, err
-			}
-			var pos structs.Position
-			if err := json.Unmarshal([]byte(posData), &pos); err != nil {
-				return nil, err
-			}
-			foodPositions = append(foodPositions, pos)
-		}
-		game.Map.Food = foodPositions
-
-		// Update the refresh interval if provided
-		if refreshInterval != 0 && refreshInterval != game.RefreshInterval {
-			_, err = db.Exec("UPDATE Games SET RefreshInterval = ? WHERE GroupID = ?", refreshInterval, groupID)
-			if err != nil {
-				return nil, err
-			}
-			game.RefreshInterval = refreshInterval
-		}
+	} else if refreshInterval != 0 {
+		// Update the refresh interval if provided, saved together with the map
+		game.RefreshInterval = refreshInterval
 	}
 
-	return &game, nil
+	return game, nil
 }
 
-func deleteGameMap(db *sql.DB, groupID string) error {
-	// 开始一个事务
-	tx, err := db.Begin()
-	if err != nil {
-		return err
-	}
+// renderMapImage 渲染地图，返回完整的画面
+// 观看者的蛇最后绘制并可以突出显示，设置了视口时只渲染视口内的区域
+func renderMapImage(gameMap *structs.GameMap, background string, view renderView) image.Image {
+	// 从配置中读取
+	blockSize := config.GetConfigValue("blocksize").(int)
 
-	// 删除所有关联的蛇
-	if _, err := tx.Exec("DELETE FROM Snakes WHERE GroupID = ?", groupID); err != nil {
-		tx.Rollback() // 回滚事务
-		return err
-	}
+	// 只渲染视口内的区域，坐标换算到区域内后按普通地图绘制
+	window := view.Viewport.window(gameMap, view.Viewer)
+	local := window.apply(gameMap)
+	canvasWidth := local.Width * blockSize
+	canvasHeight := local.Height * blockSize
 
-	// 删除所有关联的食物
-	if _, err := tx.Exec("DELETE FROM Foods WHERE GroupID = ?", groupID); err != nil {
-		tx.Rollback() // 回滚事务
-		return err
-	}
+	// 群组的背景和网格，同一背景的群组共用缓存
+	bg := groupBackground(background, canvasWidth, canvasHeight, blockSize, view.Theme)
 
-	// 删除游戏记录
-	if _, err := tx.Exec("DELETE FROM Games WHERE GroupID = ?", groupID); err != nil {
-		tx.Rollback() // 回滚事务
-		return err
+	// 创建总的画布，所有元素直接绘制在这一张画布上，不再为每条蛇分配整张画布
+	finalDC := gg.NewContext(canvasWidth, canvasHeight)
+	finalDC.DrawImage(bg, 0, 0)
+	drawWalls(finalDC, gameMap, window, blockSize, view.Theme)
+	drawTiles(finalDC, local.Tiles, blockSize, view.Theme)
+
+	// 先画食物，再按OpenID顺序画蛇，重叠时的结果固定
+	for _, foodPos := range local.Food {
+		drawFood(finalDC, foodPos, blockSize, view.Theme)
+	}
+	viewerSnake, viewerAlive := local.Snakes[view.Viewer]
+	for _, id := range snake.SortedSnakeIDs(local.Snakes) {
+		if viewerAlive && id == view.Viewer {
+			continue
+		}
+		view.drawSnake(finalDC, local.Snakes[id], blockSize)
 	}
 
-	// 提交事务
-	if err := tx.Commit(); err != nil {
-		return err
+	// 观看者的蛇画在最上层，人多时也不会被其他蛇挡住
+	if viewerAlive {
+		if view.Highlight {
+			drawGlow(finalDC, viewerSnake, blockSize, view.Theme)
+		}
+		view.drawSnake(finalDC, viewerSnake, blockSize)
+		if view.Highlight {
+			drawOutline(finalDC, viewerSnake, blockSize, view.Theme)
+		}
 	}
 
-	return nil
+	// 缩略图显示整张地图和视口所在的位置
+	if window.cropped(gameMap) && view.Viewport.MiniMap {
+		drawMiniMap(finalDC, gameMap, window, view.Viewer, config.GetConfigValue("minimapsize").(int), view.Theme)
+	}
+	return finalDC.Image()
 }
 
-// renderImageAndSave 渲染地图并保存为图片
-func renderImageAndSave(gameMap *structs.GameMap, groupID, openID string, newDirection string) error {
-	var dc *gg.Context
-	// 从配置中读取
-	blockSize := config.GetConfigValue("blocksize").(int)
-	canvasWidth := gameMap.Width * blockSize
-	canvasHeight := gameMap.Height * blockSize
-
-	// 构造缓存键
-	cacheKey := fmt.Sprintf("%s_%s_%d_%d_%d", groupID, openID, blockSize, canvasWidth, canvasHeight)
-
-	// 尝试从缓存中获取已经绘制好的图像
-	if cachedImg, ok := drawingCache.Load(cacheKey); ok {
-		dc = cachedImg.(*gg.Context)
-	} else {
-		// 如果缓存未命中，创建一个新的绘图上下文
-		dc = gg.NewContext(canvasWidth, canvasHeight)
-		// 加载并缩放背景图片
-		renderAndCacheBackground(dc, openID, canvasWidth, canvasHeight)
-
-		// 绘制网格等其他元素
-		renderGrid(dc, canvasWidth, canvasHeight, blockSize)
-		// 将完成的绘图上下文保存到缓存中
-		drawingCache.Store(cacheKey, dc)
+// drawBlock 在格子上绘制一张图片，图片缺失时使用主题的缺失颜色画出方块
+func drawBlock(dc *gg.Context, img image.Image, found bool, pos structs.Position, blockSize int, th *theme.Theme) {
+	if found {
+		dc.DrawImage(img, pos.X*blockSize, pos.Y*blockSize)
+		return
 	}
+	dc.SetColor(th.Missing) // 如果图片加载失败，使用方块表示该位置
+	dc.DrawRectangle(float64(pos.X*blockSize), float64(pos.Y*blockSize), float64(blockSize), float64(blockSize))
+	dc.Fill()
+}
 
-	width := gameMap.Width * blockSize
-	height := gameMap.Height * blockSize
-
-	// 创建总的画布
-	finalDC := gg.NewContext(width, height)
-	finalDC.DrawImage(dc.Image(), 0, 0)
-
-	// 创建等待组来同步所有goroutines
-	var wg sync.WaitGroup
-
-	// 分片处理每个部分的绘图
-	for _, snake := range gameMap.Snakes {
-		wg.Add(1)
-		go func(snake structs.Snake) {
-			defer wg.Done()
-			// 创建独立的绘图上下文
-			dc := gg.NewContext(width, height)
-			for id, pos := range snake.Positions {
-				img, found := memimg.GetAvatarFromMemory(pos.Avatar)
-				if found {
-					dc.DrawImage(img, pos.X*blockSize, pos.Y*blockSize)
-				} else {
-					// 从内存食物中获取对应的食物图像
-					foodImg, found := memimg.GetFoodFromMemory(pos.Avatar)
-					if found {
-						dc.DrawImage(foodImg, pos.X*blockSize, pos.Y*blockSize)
-					} else {
-						// 如果食物图片未找到，使用黑色矩形表示该食物位置
-						dc.SetRGB(0, 0, 0) // 如果图片加载失败，使用黑色表示该位置
-						dc.DrawRectangle(float64(pos.X*blockSize), float64(pos.Y*blockSize), float64(blockSize), float64(blockSize))
-						dc.Fill()
-					}
-				}
-
-				// 在蛇的头部画额外的线条以指示移动方向
-				if id == 0 { // 确认是蛇头
-					// 设置线条颜色为天蓝色
-					dc.SetRGB(0.68, 0.85, 1)
-					length := int(float64(blockSize) * 0.7) // 线条长度稍长
-					var direction string
-
-					// 仅查看 不改变方向
-					if newDirection == "" {
-						direction = snake.Direction
-					} else if snake.OpenID == openID {
-						// 当前操作者的箭头方向(改变方向+查看)
-						direction = newDirection
-					} else {
-						// 其他操作者的箭头方向
-						direction = snake.Direction
-					}
-
-					switch direction {
-					case "up":
-						// Draw two lines forming an upward arrow
-						dc.DrawLine(float64(pos.X*blockSize+blockSize/2), float64(pos.Y*blockSize-blockSize/2),
-							float64(pos.X*blockSize-blockSize/2), float64(pos.Y*blockSize+length))
-						dc.DrawLine(float64(pos.X*blockSize+blockSize-blockSize/2), float64(pos.Y*blockSize-blockSize/2),
-							float64(pos.X*blockSize+blockSize+blockSize/2), float64(pos.Y*blockSize+length))
-					case "down":
-						// Draw two lines forming a downward arrow
-						dc.DrawLine(float64(pos.X*blockSize+blockSize/2), float64(pos.Y*blockSize+blockSize+blockSize/2),
-							float64(pos.X*blockSize-blockSize/2), float64(pos.Y*blockSize-length+blockSize))
-						dc.DrawLine(float64(pos.X*blockSize+blockSize-blockSize/2), float64(pos.Y*blockSize+blockSize+blockSize/2),
-							float64(pos.X*blockSize+blockSize+blockSize/2), float64(pos.Y*blockSize-length+blockSize))
-					case "left":
-						// Draw two lines forming a leftward arrow
-						dc.DrawLine(float64(pos.X*blockSize-blockSize/2), float64(pos.Y*blockSize+blockSize/2),
-							float64(pos.X*blockSize+length), float64(pos.Y*blockSize-blockSize/2))
-						dc.DrawLine(float64(pos.X*blockSize-blockSize/2), float64(pos.Y*blockSize+blockSize-blockSize/2),
-							float64(pos.X*blockSize+length), float64(pos.Y*blockSize+blockSize+blockSize/2))
-					case "right":
-						// Draw two lines forming a rightward arrow
-						dc.DrawLine(float64(pos.X*blockSize+blockSize+blockSize/2), float64(pos.Y*blockSize+blockSize/2),
-							float64(pos.X*blockSize-length+blockSize), float64(pos.Y*blockSize-blockSize/2))
-						dc.DrawLine(float64(pos.X*blockSize+blockSize+blockSize/2), float64(pos.Y*blockSize+blockSize-blockSize/2),
-							float64(pos.X*blockSize-length+blockSize), float64(pos.Y*blockSize+blockSize+blockSize/2))
-					}
-					dc.Stroke() // 完成线条的绘制
-				}
+// drawFood 绘制一个食物
+func drawFood(dc *gg.Context, foodPos structs.Position, blockSize int, th *theme.Theme) {
+	foodImg, found := memimg.GetFoodFromMemory(foodPos.Avatar)
+	drawBlock(dc, foodImg, found, foodPos, blockSize, th)
+}
 
-			}
+// drawSnake 绘制一条蛇，并在蛇头画出移动方向
+func drawSnake(dc *gg.Context, s structs.Snake, blockSize int, direction string, th *theme.Theme) {
+	for id, pos := range s.Positions {
+		img, found := memimg.GetAvatarFromMemory(pos.Avatar)
+		if !found {
+			// 从内存食物中获取对应的食物图像
+			img, found = memimg.GetFoodFromMemory(pos.Avatar)
+		}
+		drawBlock(dc, img, found, pos, blockSize, th)
 
-			// 画布上锁并合并到总画布
-			finalDC.DrawImage(dc.Image(), 0, 0)
-		}(snake)
+		// 在蛇的头部画额外的线条以指示移动方向
+		if id == 0 { // 确认是蛇头
+			drawDirectionArrow(dc, pos, blockSize, direction, th.Arrow)
+		}
 	}
+}
 
-	// 食物的绘制也并行处理
-	for _, foodPos := range gameMap.Food {
-		wg.Add(1)
-		go func(foodPos structs.Position) {
-			defer wg.Done()
-			dc := gg.NewContext(width, height)
-			foodImg, found := memimg.GetFoodFromMemory(foodPos.Avatar)
-			if found {
-				dc.DrawImage(foodImg, foodPos.X*blockSize, foodPos.Y*blockSize)
-			} else {
-				dc.SetRGB(0, 0, 0)
-				dc.DrawRectangle(float64(foodPos.X*blockSize), float64(foodPos.Y*blockSize), float64(blockSize), float64(blockSize))
-				dc.Fill()
-			}
-			// 画布上锁并合并到总画布
-			finalDC.DrawImage(dc.Image(), 0, 0)
-		}(foodPos)
+// drawDirectionArrow 在蛇头所在的格子画出指示方向的箭头
+func drawDirectionArrow(dc *gg.Context, pos structs.Position, blockSize int, direction string, arrow color.Color) {
+	// 线条颜色由主题决定，默认为天蓝色
+	dc.SetColor(arrow)
+	length := int(float64(blockSize) * 0.7) // 线条长度稍长
+
+	switch direction {
+	case "up":
+		// Draw two lines forming an upward arrow
+		dc.DrawLine(float64(pos.X*blockSize+blockSize/2), float64(pos.Y*blockSize-blockSize/2),
+			float64(pos.X*blockSize-blockSize/2), float64(pos.Y*blockSize+length))
+		dc.DrawLine(float64(pos.X*blockSize+blockSize-blockSize/2), float64(pos.Y*blockSize-blockSize/2),
+			float64(pos.X*blockSize+blockSize+blockSize/2), float64(pos.Y*blockSize+length))
+	case "down":
+		// Draw two lines forming a downward arrow
+		dc.DrawLine(float64(pos.X*blockSize+blockSize/2), float64(pos.Y*blockSize+blockSize+blockSize/2),
+			float64(pos.X*blockSize-blockSize/2), float64(pos.Y*blockSize-length+blockSize))
+		dc.DrawLine(float64(pos.X*blockSize+blockSize-blockSize/2), float64(pos.Y*blockSize+blockSize+blockSize/2),
+			float64(pos.X*blockSize+blockSize+blockSize/2), float64(pos.Y*blockSize-length+blockSize))
+	case "left":
+		// Draw two lines forming a leftward arrow
+		dc.DrawLine(float64(pos.X*blockSize-blockSize/2), float64(pos.Y*blockSize+blockSize/2),
+			float64(pos.X*blockSize+length), float64(pos.Y*blockSize-blockSize/2))
+		dc.DrawLine(float64(pos.X*blockSize-blockSize/2), float64(pos.Y*blockSize+blockSize-blockSize/2),
+			float64(pos.X*blockSize+length), float64(pos.Y*blockSize+blockSize+blockSize/2))
+	case "right":
+		// Draw two lines forming a rightward arrow
+		dc.DrawLine(float64(pos.X*blockSize+blockSize+blockSize/2), float64(pos.Y*blockSize+blockSize/2),
+			float64(pos.X*blockSize-length+blockSize), float64(pos.Y*blockSize-blockSize/2))
+		dc.DrawLine(float64(pos.X*blockSize+blockSize+blockSize/2), float64(pos.Y*blockSize+blockSize-blockSize/2),
+			float64(pos.X*blockSize-length+blockSize), float64(pos.Y*blockSize+blockSize+blockSize/2))
 	}
-
-	// 等待所有goroutines完成
-	wg.Wait()
-
-	// 保存图片
-	fileName := fmt.Sprintf("./static/%s.jpg", groupID)
-	os.MkdirAll(filepath.Dir(fileName), os.ModePerm)
-	return finalDC.SavePNG(fileName)
+	dc.Stroke() // 完成线条的绘制
 }
 
 func scaleImage(img image.Image, newWidth, newHeight int) image.Image {
@@ -503,27 +675,29 @@ func PreloadAndScaleFoods(foodDirectory string, blockSize int) {
 	})
 }
 
-func renderAndCacheBackground(dc *gg.Context, openID string, width, height int) {
-	backgroundFileName := fmt.Sprintf("%s_blur.jpg", openID)
-	bgImg, found := memimg.GetAvatarFromMemory(backgroundFileName)
-	if found {
-		// 缩放并定位背景图像
-		bgWidth := float64(bgImg.Bounds().Dx())
-		bgHeight := float64(bgImg.Bounds().Dy())
-		scale := math.Max(float64(width)/bgWidth, float64(height)/bgHeight)
-		dc.Scale(scale, scale)
-		offsetX := (float64(width) - bgWidth*scale) / 2.0 / scale
-		offsetY := (float64(height) - bgHeight*scale) / 2.0 / scale
-		dc.DrawImage(bgImg, int(offsetX), int(offsetY))
-	} else {
-		dc.SetRGB(1, 1, 1)
-		dc.Clear()
+// renderGrid 按主题的样式绘制网格
+func renderGrid(dc *gg.Context, width, height, blockSize int, grid theme.Grid) {
+	if grid.Style == theme.GridNone {
+		return
+	}
+	dc.Push()
+	defer dc.Pop()
+	dc.SetColor(grid.Color)
+	dc.SetLineWidth(grid.Width)
+	if grid.Style == theme.GridDotted {
+		// 只在格子的交点画点
+		for x := 0; x <= width; x += blockSize {
+			for y := 0; y <= height; y += blockSize {
+				dc.DrawPoint(float64(x), float64(y), grid.Width)
+			}
+		}
+		dc.Fill()
+		return
+	}
+	if grid.Style == theme.GridDashed {
+		dash := float64(blockSize) / 4
+		dc.SetDash(dash, dash)
 	}
-	dc.Identity()
-}
-
-func renderGrid(dc *gg.Context, width, height, blockSize int) {
-	dc.SetRGB(0.9, 0.9, 0.9)
 	for x := 0; x <= width; x += blockSize {
 		dc.DrawLine(float64(x), 0, float64(x), float64(height))
 		dc.Stroke()
@@ -0,0 +1,286 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hoshinonyaruko/snake-in-im/repository"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// seedGame 直接在存储中创建一个还没有到刷新时间的游戏
+func seedGame(t *testing.T, repo repository.GameRepository, groupID string, snakes ...structs.Snake) *structs.Game {
+	t.Helper()
+	game := &structs.Game{
+		GroupID:         groupID,
+		RefreshInterval: 3600,
+		LastRefresh:     time.Now().Unix(),
+		Seed:            1,
+		Background:      "color:#203040",
+		Nicknames:       map[string]string{},
+		Map: structs.GameMap{
+			Snakes: make(map[string]structs.Snake),
+			Food:   []structs.Position{{X: 0, Y: 0, Avatar: "food_small.png", Food: "food"}},
+			Width:  10,
+			Height: 10,
+			Edges:  "wrap",
+		},
+	}
+	for _, s := range snakes {
+		game.Map.Snakes[s.OpenID] = s
+	}
+	if err := repo.Create(game); err != nil {
+		t.Fatal(err)
+	}
+	return game
+}
+
+func TestRenderMapHandler(t *testing.T) {
+	repo := repository.NewMemory()
+	router := newTestRouter(repo)
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"unknown edge mode", "groupid=render&openid=alice&edges=spiral", http.StatusBadRequest},
+		{"unknown level", "groupid=render&openid=alice&level=nope", http.StatusBadRequest},
+		{"bad background", "groupid=render&openid=alice&background=pattern:x", http.StatusBadRequest},
+		{"unknown format", "groupid=render&openid=alice&format=bmp", http.StatusBadRequest},
+		{"unknown renderer", "groupid=render&openid=alice&renderer=ascii", http.StatusBadRequest},
+		{"creates the game", "groupid=render&openid=alice&width=12&height=8&format=png", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := doGet(t, router, "/render-map?"+tt.query)
+			if code != tt.status {
+				t.Fatalf("status %d, want %d: %v", code, tt.status, body)
+			}
+		})
+	}
+
+	game, err := repo.Load("render")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if game.Map.Width != 12 || game.Map.Height != 8 || len(game.Map.Snakes["alice"].Positions) != 1 {
+		t.Fatalf("unexpected game %+v", game.Map)
+	}
+
+	code, body := doGet(t, router, "/render-map?groupid=render&openid=bob&mode=both&format=png")
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %v", code, body)
+	}
+	if body["content_type"] != "image/png" {
+		t.Errorf("content_type %v, want image/png", body["content_type"])
+	}
+	imageURL, _ := body["image_url"].(string)
+	index := strings.Index(imageURL, "/static/renders/")
+	if index < 0 {
+		t.Fatalf("image_url %q is not a static render", imageURL)
+	}
+	if _, err := os.Stat("." + imageURL[index:]); err != nil {
+		t.Errorf("rendered image was not written: %v", err)
+	}
+	if text, _ := body["text"].(string); text == "" {
+		t.Error("mode=both returned no text map")
+	}
+
+	code, body = doGet(t, router, "/render-map?groupid=render&openid=bob&mode=text")
+	if code != http.StatusOK || body["image_url"] != nil || body["text"] == nil {
+		t.Fatalf("text mode: status %d, %v", code, body)
+	}
+}
+
+func TestRenderMapHandlerRenderFailure(t *testing.T) {
+	RegisterRenderer("broken", RendererFunc(func(game *structs.Game, viewer string, opts RenderOptions) ([]byte, string, error) {
+		return nil, "", errors.New("out of ink")
+	}))
+	repo := repository.NewMemory()
+	router := newTestRouter(repo)
+
+	code, body := doGet(t, router, "/render-map?groupid=broken&openid=alice&renderer=broken")
+	if code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500: %v", code, body)
+	}
+	if _, ok := body["image_url"]; ok {
+		t.Errorf("image_url returned for a failed render: %v", body)
+	}
+	if _, ok := body["content_type"]; ok {
+		t.Errorf("content_type returned for a failed render: %v", body)
+	}
+	if msg, _ := body["error"].(string); !strings.Contains(msg, "out of ink") {
+		t.Errorf("error %q does not explain the failure", msg)
+	}
+	// 渲染失败不影响游戏的保存
+	if _, err := repo.Load("broken"); err != nil {
+		t.Errorf("game was not saved: %v", err)
+	}
+
+	// 同时请求文字地图时仍然返回文字
+	code, body = doGet(t, router, "/render-map?groupid=broken&openid=alice&renderer=broken&mode=both")
+	if code != http.StatusOK || body["text"] == nil || body["error"] == nil || body["image_url"] != nil {
+		t.Errorf("mode=both: status %d, %v", code, body)
+	}
+}
+
+func TestStateHandler(t *testing.T) {
+	repo := repository.NewMemory()
+	router := newTestRouter(repo)
+
+	if code, _ := doGet(t, router, "/state"); code != http.StatusBadRequest {
+		t.Errorf("missing groupid: status %d", code)
+	}
+	if code, _ := doGet(t, router, "/state?groupid=nobody"); code != http.StatusNotFound {
+		t.Errorf("unknown group: status %d", code)
+	}
+
+	seedGame(t, repo, "state",
+		structs.Snake{OpenID: "short", Direction: "up", Positions: []structs.Position{{X: 1, Y: 1}}},
+		structs.Snake{OpenID: "long", Direction: "down", Positions: []structs.Position{{X: 5, Y: 5}, {X: 5, Y: 4}}},
+	)
+	code, body := doGet(t, router, "/state?groupid=state")
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %v", code, body)
+	}
+	if body["schema_version"] != float64(structs.StateSchemaVersion) || body["width"] != float64(10) {
+		t.Errorf("unexpected state %v", body)
+	}
+	snakes, _ := body["snakes"].([]any)
+	if len(snakes) != 2 {
+		t.Fatalf("snakes %v", body["snakes"])
+	}
+	first := snakes[0].(map[string]any)
+	if first["open_id"] != "long" || first["rank"] != float64(1) || first["length"] != float64(2) {
+		t.Errorf("first ranked snake %v", first)
+	}
+	// 到期的刷新只用于计算返回的状态，不会保存
+	game := seedGame(t, repo, "due", structs.Snake{OpenID: "a", Direction: "up", Positions: []structs.Position{{X: 3, Y: 3}}})
+	game.LastRefresh -= 3 * int64(game.RefreshInterval)
+	if err := repo.Save(game, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		code, body = doGet(t, router, "/state?groupid=due")
+		if code != http.StatusOK || body["tick"] != float64(3) {
+			t.Fatalf("status %d, tick %v, want 3", code, body["tick"])
+		}
+	}
+	stored, err := repo.Load("due")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Tick != 0 || stored.LastRefresh != game.LastRefresh || stored.Map.Snakes["a"].Positions[0] != (structs.Position{X: 3, Y: 3}) {
+		t.Errorf("state saved the game: tick %d, last refresh %d, snake %v", stored.Tick, stored.LastRefresh, stored.Map.Snakes["a"])
+	}
+
+	// 预览中的淘汰计入击杀数和排名
+	game = seedGame(t, repo, "kills",
+		structs.Snake{OpenID: "hunter", Direction: "down", Positions: []structs.Position{{X: 5, Y: 4}, {X: 5, Y: 3}}},
+		structs.Snake{OpenID: "prey", Direction: "right", Positions: []structs.Position{{X: 5, Y: 5}, {X: 4, Y: 5}}},
+		structs.Snake{OpenID: "rival", Direction: "up", Positions: []structs.Position{{X: 1, Y: 8}, {X: 1, Y: 9}, {X: 2, Y: 9}}},
+	)
+	game.LastRefresh -= int64(game.RefreshInterval)
+	game.Tick = 1
+	if err := repo.Save(game, []structs.Death{{OpenID: "old", KillerID: "hunter", Length: 1, Tick: 1, Cause: "eaten"}}); err != nil {
+		t.Fatal(err)
+	}
+	code, body = doGet(t, router, "/state?groupid=kills")
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %v", code, body)
+	}
+	snakes, _ = body["snakes"].([]any)
+	if len(snakes) != 2 {
+		t.Fatalf("snakes %v", body["snakes"])
+	}
+	// hunter吃掉prey后与rival同样长，击杀数更多排在前面
+	first = snakes[0].(map[string]any)
+	if first["open_id"] != "hunter" || first["kills"] != float64(2) || first["rank"] != float64(1) {
+		t.Errorf("first ranked snake %v, want hunter with 2 kills", first)
+	}
+	if deaths, _ := body["deaths"].([]any); len(deaths) != 1 || deaths[0].(map[string]any)["open_id"] != "prey" {
+		t.Errorf("preview deaths %v", body["deaths"])
+	}
+}
+
+func TestDeathsHandler(t *testing.T) {
+	repo := repository.NewMemory()
+	router := newTestRouter(repo)
+
+	if code, _ := doGet(t, router, "/deaths"); code != http.StatusBadRequest {
+		t.Errorf("missing groupid: status %d", code)
+	}
+
+	game := seedGame(t, repo, "deaths")
+	deaths := []structs.Death{
+		{OpenID: "a", KillerID: "b", Length: 2, Tick: 3, Cause: "eaten"},
+		{OpenID: "c", Length: 1, Tick: 7, Cause: "self"},
+	}
+	if err := repo.Save(game, deaths); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"groupid=deaths", []string{"a", "c"}},
+		{"groupid=deaths&after_tick=3", []string{"c"}},
+		{"groupid=deaths&after_tick=7", nil},
+		{"groupid=other", nil},
+	} {
+		code, body := doGet(t, router, "/deaths?"+tt.query)
+		if code != http.StatusOK {
+			t.Fatalf("%s: status %d", tt.query, code)
+		}
+		list, _ := body["deaths"].([]any)
+		var got []string
+		for _, d := range list {
+			got = append(got, d.(map[string]any)["open_id"].(string))
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: deaths %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestDeleteMapHandler(t *testing.T) {
+	repo := repository.NewMemory()
+	router := newTestRouter(repo)
+
+	if code, _ := doGet(t, router, "/delete-map"); code != http.StatusBadRequest {
+		t.Errorf("missing groupid: status %d", code)
+	}
+
+	if code, body := doGet(t, router, "/render-map?groupid=delete&openid=alice&format=png"); code != http.StatusOK {
+		t.Fatalf("render: status %d: %v", code, body)
+	}
+	dir := filepath.Join(rendersDir, safeName("delete"))
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatalf("renders were not written: %v", err)
+	}
+
+	if code, body := doGet(t, router, "/delete-map?groupid=delete"); code != http.StatusOK {
+		t.Fatalf("delete: status %d: %v", code, body)
+	}
+	if _, err := repo.Load("delete"); err != repository.ErrNotFound {
+		t.Errorf("game still exists: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("renders were not removed: %v", err)
+	}
+	if code, _ := doGet(t, router, "/state?groupid=delete"); code != http.StatusNotFound {
+		t.Errorf("state after delete: status %d", code)
+	}
+
+	// 删除不存在的地图不是错误
+	if code, _ := doGet(t, router, "/delete-map?groupid=delete"); code != http.StatusOK {
+		t.Errorf("second delete: status %d", code)
+	}
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"container/list"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/fogleman/gg"
+	"github.com/hoshinonyaruko/snake-in-im/config"
+	"github.com/hoshinonyaruko/snake-in-im/memimg"
+	"github.com/hoshinonyaruko/snake-in-im/theme"
+)
+
+// 背景的种类，群组的背景写作 "<种类>:<值>"
+const (
+	backgroundAvatar = "avatar" // 玩家头像的模糊图，值为OpenID
+	backgroundImage  = "image"  // backgrounds目录中的图片，值为去掉扩展名的文件名
+	backgroundColor  = "color"  // 纯色，值为十六进制颜色
+)
+
+// parseBackground 检查并拆分背景设置
+func parseBackground(spec string) (kind, value string, err error) {
+	kind, value, ok := strings.Cut(spec, ":")
+	if !ok || value == "" {
+		return "", "", fmt.Errorf("invalid background '%s', expected avatar:<openid>, image:<name> or color:#rrggbb", spec)
+	}
+	switch kind {
+	case backgroundAvatar, backgroundImage:
+		return kind, value, nil
+	case backgroundColor:
+		hex := strings.TrimPrefix(value, "#")
+		if len(hex) != 3 && len(hex) != 6 && len(hex) != 8 {
+			return "", "", fmt.Errorf("invalid background color '%s'", value)
+		}
+		for _, r := range hex {
+			if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+				return "", "", fmt.Errorf("invalid background color '%s'", value)
+			}
+		}
+		return kind, "#" + hex, nil
+	default:
+		return "", "", fmt.Errorf("unknown background kind '%s'", kind)
+	}
+}
+
+// defaultBackgroundCacheSize 背景缓存的默认条数上限，配置不大于0时使用
+const defaultBackgroundCacheSize = 64
+
+// backgroundCache 缓存绘制好背景和网格的画面，按条数限制，淘汰最久未使用的
+// 键以背景设置开头，同一背景的群组共用缓存，头像或背景图片更新时按前缀失效
+// 键中包含主题的名称和版本，主题更新后旧的画面不会再被使用
+type backgroundCache struct {
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type backgroundEntry struct {
+	key string
+	img image.Image
+}
+
+// 全局背景缓存
+var backgrounds = &backgroundCache{ll: list.New(), items: make(map[string]*list.Element)}
+
+func (c *backgroundCache) Get(key string) (image.Image, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return elem.Value.(*backgroundEntry).img, true
+}
+
+func (c *backgroundCache) Put(key string, img image.Image) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*backgroundEntry).img = img
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&backgroundEntry{key: key, img: img})
+	max := config.GetConfigValue("backgroundcachesize").(int)
+	if max <= 0 {
+		max = defaultBackgroundCacheSize
+	}
+	for c.ll.Len() > max {
+		elem := c.ll.Back()
+		c.ll.Remove(elem)
+		delete(c.items, elem.Value.(*backgroundEntry).key)
+	}
+}
+
+// Invalidate 删除某个背景设置的所有缓存
+func (c *backgroundCache) Invalidate(spec string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, elem := range c.items {
+		if strings.HasPrefix(key, spec+"|") {
+			c.ll.Remove(elem)
+			delete(c.items, key)
+		}
+	}
+}
+
+// InvalidateAvatarBackground 玩家头像更新后调用，使用该头像的背景需要重新绘制
+func InvalidateAvatarBackground(openID string) {
+	backgrounds.Invalidate(backgroundAvatar + ":" + openID)
+}
+
+// InvalidateImageBackground 背景图片更新后调用
+func InvalidateImageBackground(name string) {
+	backgrounds.Invalidate(backgroundImage + ":" + name)
+}
+
+// InvalidateTheme 主题更新或删除后调用，释放该主题的缓存
+func InvalidateTheme(name string) {
+	backgrounds.mu.Lock()
+	for key, elem := range backgrounds.items {
+		if strings.Contains(key, "|theme:"+name+"@") {
+			backgrounds.ll.Remove(elem)
+			delete(backgrounds.items, key)
+		}
+	}
+	backgrounds.mu.Unlock()
+
+	themeSpritesMutex.Lock()
+	delete(themeSprites, name)
+	themeSpritesMutex.Unlock()
+}
+
+// groupBackground 返回绘制好背景和网格的画面，返回的图片只读，不能在上面绘制
+// 主题自带背景时替换群组的背景
+func groupBackground(spec string, width, height, blockSize int, th *theme.Theme) image.Image {
+	key := fmt.Sprintf("%s|theme:%s@%d|%d|%d|%d", spec, th.Name, th.Version, blockSize, width, height)
+	if img, ok := backgrounds.Get(key); ok {
+		return img
+	}
+
+	dc := gg.NewContext(width, height)
+	if th.HasBackground() {
+		renderThemeBackground(dc, th, width, height)
+	} else {
+		renderBackground(dc, spec, width, height)
+	}
+	renderGrid(dc, width, height, blockSize, th.Grid)
+	backgrounds.Put(key, dc.Image())
+	return dc.Image()
+}
+
+// renderBackground 绘制背景，无效的设置或缺失的图片使用白色
+func renderBackground(dc *gg.Context, spec string, width, height int) {
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+
+	kind, value, err := parseBackground(spec)
+	if err != nil {
+		return
+	}
+
+	var bgImg image.Image
+	var found bool
+	switch kind {
+	case backgroundColor:
+		dc.SetHexColor(value)
+		dc.Clear()
+		return
+	case backgroundAvatar:
+		bgImg, found = memimg.GetAvatarFromMemory(fmt.Sprintf("%s_blur.jpg", value))
+	case backgroundImage:
+		bgImg, found = memimg.GetBackgroundFromMemory(value)
+	}
+	if !found {
+		return
+	}
+	drawCover(dc, bgImg, width, height)
+}
+
+// renderThemeBackground 绘制主题自带的背景
+func renderThemeBackground(dc *gg.Context, th *theme.Theme, width, height int) {
+	dc.SetRGB(1, 1, 1)
+	if th.BackgroundColor != nil {
+		dc.SetColor(*th.BackgroundColor)
+	}
+	dc.Clear()
+	if th.BackgroundImage != nil {
+		drawCover(dc, th.BackgroundImage, width, height)
+	}
+}
+
+// drawCover 缩放并定位背景图像，铺满整个画布
+func drawCover(dc *gg.Context, bgImg image.Image, width, height int) {
+	bgWidth := float64(bgImg.Bounds().Dx())
+	bgHeight := float64(bgImg.Bounds().Dy())
+	scale := math.Max(float64(width)/bgWidth, float64(height)/bgHeight)
+	dc.Scale(scale, scale)
+	offsetX := (float64(width) - bgWidth*scale) / 2.0 / scale
+	offsetY := (float64(height) - bgHeight*scale) / 2.0 / scale
+	dc.DrawImage(bgImg, int(offsetX), int(offsetY))
+	dc.Identity()
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"container/list"
+	"fmt"
+	"image"
+	"net/http"
+	"testing"
+
+	"github.com/hoshinonyaruko/snake-in-im/repository"
+)
+
+func TestBackgroundCacheIsBounded(t *testing.T) {
+	cache := &backgroundCache{ll: list.New(), items: make(map[string]*list.Element)}
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	for i := 0; i < 3*defaultBackgroundCacheSize; i++ {
+		cache.Put(fmt.Sprintf("color:#%06x|theme:default@0|20|400|400", i), img)
+	}
+	if n := cache.ll.Len(); n != defaultBackgroundCacheSize || len(cache.items) != n {
+		t.Fatalf("cache holds %d entries (%d keys), want %d", n, len(cache.items), defaultBackgroundCacheSize)
+	}
+	// 最近放入的保留，最早的被淘汰
+	if _, ok := cache.Get(fmt.Sprintf("color:#%06x|theme:default@0|20|400|400", 3*defaultBackgroundCacheSize-1)); !ok {
+		t.Error("newest background was evicted")
+	}
+	if _, ok := cache.Get("color:#000000|theme:default@0|20|400|400"); ok {
+		t.Error("oldest background was not evicted")
+	}
+}
+
+func TestLegacyGameWithoutBackground(t *testing.T) {
+	repo := repository.NewMemory()
+	router := newTestRouter(repo)
+
+	game := seedGame(t, repo, "legacy")
+	game.Background = ""
+	if err := repo.Save(game, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, body := doGet(t, router, "/render-map?groupid=legacy&openid=bob&format=png"); code != http.StatusOK || body["image_url"] == nil {
+		t.Fatalf("status %d: %v", code, body)
+	}
+	stored, err := repo.Load("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Background != "avatar:bob" {
+		t.Errorf("background %q, want avatar:bob", stored.Background)
+	}
+	if _, _, err := parseBackground(stored.Background); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hoshinonyaruko/snake-in-im/config"
+	"github.com/hoshinonyaruko/snake-in-im/food"
+	"github.com/hoshinonyaruko/snake-in-im/repository"
+	"github.com/hoshinonyaruko/snake-in-im/snake"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// maxSpawnFood 一次最多放置的食物数量
+const maxSpawnFood = 20
+
+// adminTokenHeader 管理员请求携带口令的请求头
+const adminTokenHeader = "X-Admin-Token"
+
+// isAdmin 请求者是否是配置中的管理员
+// openid由调用方（机器人框架）传入，服务本身无法验证，因此还要求请求头中的口令与admintoken一致，
+// 防止能直接访问服务的玩家冒用管理员的openid；没有配置admintoken时不承认任何管理员
+func isAdmin(c *gin.Context, openID string) bool {
+	token := config.GetConfigValue("admintoken").(string)
+	if openID == "" || token == "" {
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(c.GetHeader(adminTokenHeader)), []byte(token)) != 1 {
+		return false
+	}
+	for _, admin := range config.GetConfigValue("admins").([]string) {
+		if admin == openID {
+			return true
+		}
+	}
+	return false
+}
+
+// effectLabels 蛇身上的食物效果和剩余的刷新次数，用于文字地图的图例
+func effectLabels(s structs.Snake) string {
+	var labels strings.Builder
+	for _, effect := range []struct {
+		name      string
+		remaining int
+	}{{"加速", s.SpeedUp}, {"护盾", s.Shield}, {"中毒", s.Poison}} {
+		if effect.remaining > 0 {
+			fmt.Fprintf(&labels, " %s%d", effect.name, effect.remaining)
+		}
+	}
+	return labels.String()
+}
+
+// checkFoodAllowed 只有管理员可以放置的食物需要管理员的OpenID
+func checkFoodAllowed(c *gin.Context, foodName, openID string) error {
+	if foodName == food.RandomName {
+		return nil
+	}
+	if f, ok := food.Get(foodName); ok && f.Admin && !isAdmin(c, openID) {
+		return fmt.Errorf("food %q can only be placed by admins", foodName)
+	}
+	return nil
+}
+
+// SpawnFoodHandler 管理员在地图上放置食物，例如活动用的金苹果
+// 指定x和y时放在该位置，否则随机放置count个，不会刷新地图
+func SpawnFoodHandler(repo repository.GameRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		groupID := c.Query("groupid")
+		openID := c.Query("openid")
+		foodName := c.Query("food")
+		if groupID == "" || foodName == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required query parameters: groupid or food"})
+			return
+		}
+		if !isAdmin(c, openID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only admins can spawn food"})
+			return
+		}
+		if _, ok := food.Get(foodName); !ok && foodName != food.RandomName {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown food %q", foodName)})
+			return
+		}
+		count, err := strconv.Atoi(c.DefaultQuery("count", "1"))
+		if err != nil || count < 1 || count > maxSpawnFood {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("count must be between 1 and %d", maxSpawnFood)})
+			return
+		}
+		xValue, yValue := c.Query("x"), c.Query("y")
+		x, errX := strconv.Atoi(xValue)
+		y, errY := strconv.Atoi(yValue)
+		positioned := xValue != "" || yValue != ""
+		if positioned && (errX != nil || errY != nil) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "x and y must both be integers"})
+			return
+		}
+
+		// 同一群组的请求依次执行
+		unlock := groupLocks.Lock(groupID)
+		defer unlock()
+
+		game, err := repo.Load(groupID)
+		if err == repository.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Game map not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to load game map for groupID %s: %v", groupID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to fetch game map"})
+			return
+		}
+
+		before := len(game.Map.Food)
+		if positioned {
+			name := foodName
+			if name == food.RandomName {
+				name = snake.RandomFood(game).Name
+			}
+			if !snake.PlaceFoodAt(game, name, x, y) {
+				c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("(%d, %d) is outside the map or occupied", x, y)})
+				return
+			}
+		} else {
+			for i := 0; i < count && snake.HasFreeCell(&game.Map); i++ {
+				name := foodName
+				if name == food.RandomName {
+					name = snake.RandomFood(game).Name
+				}
+				snake.AddFoodToGameMap(game, name)
+			}
+		}
+
+		if err := repo.Save(game, nil); err != nil {
+			log.Printf("Failed to save game map for groupID %s: %v", groupID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to save game map"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"food": game.Map.Food[before:]})
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hoshinonyaruko/snake-in-im/config"
+	"github.com/hoshinonyaruko/snake-in-im/food/foodtest"
+	"github.com/hoshinonyaruko/snake-in-im/repository"
+)
+
+// useAdmins 在测试期间使用指定的管理员和口令
+func useAdmins(t *testing.T, token string, admins ...string) {
+	t.Helper()
+	cfg := config.LoadConfig("")
+	oldAdmins, oldToken := cfg.Admins, cfg.AdminToken
+	cfg.Admins, cfg.AdminToken = admins, token
+	t.Cleanup(func() { cfg.Admins, cfg.AdminToken = oldAdmins, oldToken })
+}
+
+// doGetWithToken 发送带有管理员口令的GET请求，token为空时不设置请求头
+func doGetWithToken(t *testing.T, router http.Handler, target, token string) (int, map[string]any) {
+	t.Helper()
+	request := httptest.NewRequest(http.MethodGet, target, nil)
+	if token != "" {
+		request.Header.Set(adminTokenHeader, token)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	var body map[string]any
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Errorf("GET %s: invalid JSON %q: %v", target, recorder.Body.String(), err)
+	}
+	return recorder.Code, body
+}
+
+func TestAdminToken(t *testing.T) {
+	foodtest.UseManifest(t, `[{"name": "golden_apple", "growth": 3, "admin": true}]`)
+
+	tests := []struct {
+		name   string
+		token  string // 配置的口令
+		openID string
+		header string // 请求携带的口令
+		want   int
+	}{
+		// 没有配置口令时管理员不生效，只凭openid不能冒用管理员
+		{"no token configured, admin", "", "admin1", "", http.StatusForbidden},
+		{"no token configured, player", "", "bob", "", http.StatusForbidden},
+		{"token configured, admin with token", "s3cret", "admin1", "s3cret", http.StatusOK},
+		{"token configured, admin without token", "s3cret", "admin1", "", http.StatusForbidden},
+		{"token configured, admin with wrong token", "s3cret", "admin1", "s3cre", http.StatusForbidden},
+		{"token configured, player with token", "s3cret", "bob", "s3cret", http.StatusForbidden},
+		{"token configured, no openid", "s3cret", "", "s3cret", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useAdmins(t, tt.token, "admin1")
+			repo := repository.NewMemory()
+			router := newTestRouter(repo)
+			seedGame(t, repo, "admins")
+
+			code, body := doGetWithToken(t, router, "/spawn-food?groupid=admins&food=golden_apple&x=5&y=5&openid="+tt.openID, tt.header)
+			if code != tt.want {
+				t.Fatalf("spawn-food: status %d, want %d: %v", code, tt.want, body)
+			}
+			// 渲染地图时放置只有管理员可以放置的食物，使用同样的检查
+			code, body = doGetWithToken(t, router, "/render-map?groupid=admins&format=png&foodname=golden_apple&openid="+tt.openID, tt.header)
+			if code != tt.want {
+				t.Fatalf("render-map: status %d, want %d: %v", code, tt.want, body)
+			}
+			// 普通食物不需要管理员
+			if code, body := doGetWithToken(t, router, "/render-map?groupid=admins&format=png&foodname=food&openid=bob", ""); code != http.StatusOK {
+				t.Errorf("plain food: status %d: %v", code, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strings"
+
+	"github.com/chai2010/webp"
+	"github.com/disintegration/imaging"
+)
+
+// imageFormat 描述一种图片输出格式
+type imageFormat struct {
+	Name        string // 格式名，与请求参数format一致
+	Ext         string // 文件扩展名
+	ContentType string // HTTP Content-Type
+	Lossy       bool   // 是否可以通过降低质量减小体积
+}
+
+// 支持的输出格式
+var imageFormats = map[string]imageFormat{
+	"jpeg":          {Name: "jpeg", Ext: ".jpg", ContentType: "image/jpeg", Lossy: true},
+	"png":           {Name: "png", Ext: ".png", ContentType: "image/png"},
+	"webp":          {Name: "webp", Ext: ".webp", ContentType: "image/webp", Lossy: true},
+	"webp_lossless": {Name: "webp_lossless", Ext: ".webp", ContentType: "image/webp"},
+}
+
+// 体积超出限制时的最低质量和最多缩小次数
+const (
+	minEncodeQuality = 30
+	maxDownscales    = 4
+)
+
+// encodeOptions 图片编码参数
+type encodeOptions struct {
+	Format   string // 输出格式，见imageFormats
+	Quality  int    // 有损格式的质量，1-100
+	MaxBytes int    // 体积上限，0表示不限制
+}
+
+// lookupImageFormat 查找输出格式，支持"jpg"作为"jpeg"的别名
+func lookupImageFormat(name string) (imageFormat, error) {
+	name = strings.ToLower(name)
+	if name == "jpg" {
+		name = "jpeg"
+	}
+	format, ok := imageFormats[name]
+	if !ok {
+		return imageFormat{}, fmt.Errorf("unsupported image format '%s'", name)
+	}
+	return format, nil
+}
+
+// encodeImage 按指定格式编码图片
+// 超出体积上限时先逐步降低有损格式的质量，仍然超出则按比例缩小图片
+func encodeImage(img image.Image, opts encodeOptions) ([]byte, imageFormat, error) {
+	format, err := lookupImageFormat(opts.Format)
+	if err != nil {
+		return nil, format, err
+	}
+	quality := opts.Quality
+	if quality <= 0 || quality > 100 {
+		quality = 90
+	}
+
+	for downscales := 0; ; downscales++ {
+		for q := quality; ; q -= 10 {
+			data, err := encodeWithQuality(img, format, q)
+			if err != nil {
+				return nil, format, err
+			}
+			if opts.MaxBytes <= 0 || len(data) <= opts.MaxBytes {
+				return data, format, nil
+			}
+			if !format.Lossy || q-10 < minEncodeQuality {
+				break
+			}
+		}
+		if downscales == maxDownscales {
+			return nil, format, fmt.Errorf("unable to encode image within %d bytes", opts.MaxBytes)
+		}
+		bounds := img.Bounds()
+		img = imaging.Resize(img, bounds.Dx()*3/4, 0, imaging.Lanczos)
+	}
+}
+
+func encodeWithQuality(img image.Image, format imageFormat, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format.Name {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	case "png":
+		err = png.Encode(&buf, img)
+	case "webp":
+		err = webp.Encode(&buf, img, &webp.Options{Quality: float32(quality)})
+	case "webp_lossless":
+		err = webp.Encode(&buf, img, &webp.Options{Lossless: true})
+	}
+	return buf.Bytes(), err
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"bytes"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+
+	"github.com/hoshinonyaruko/snake-in-im/config"
+	"github.com/hoshinonyaruko/snake-in-im/snake"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// replayRecorder 记录刷新过程中的每一帧地图，只保留最近的maxFrames帧
+type replayRecorder struct {
+	maxFrames int
+	frames    []structs.GameMap
+}
+
+func newReplayRecorder(maxFrames int) *replayRecorder {
+	if maxFrames < 1 {
+		maxFrames = 1
+	}
+	return &replayRecorder{maxFrames: maxFrames}
+}
+
+// record 作为Engine.UpdateEach的回调使用
+func (r *replayRecorder) record(game *structs.Game) {
+	if len(r.frames) == r.maxFrames {
+		r.frames = r.frames[1:]
+	}
+	r.frames = append(r.frames, snake.CloneGameMap(game.Map))
+}
+
+// renderGIF 把记录的帧渲染为GIF动画，opts.FrameDelay为每帧的时长，单位毫秒
+// 最后一帧使用当前地图，包含刷新之后添加的食物和观看者的方向箭头
+func renderGIF(game *structs.Game, viewer string, opts RenderOptions) ([]byte, string, error) {
+	frames := opts.Frames
+	if len(frames) > 0 {
+		frames = frames[:len(frames)-1]
+	}
+	delay := opts.FrameDelay
+	if delay <= 0 {
+		delay = config.GetConfigValue("gifframedelay").(int)
+	}
+
+	anim := &gif.GIF{}
+	addFrame := func(img image.Image) {
+		// 使用固定调色板和误差扩散，保证每一帧颜色一致
+		paletted := image.NewPaletted(img.Bounds(), palette.Plan9)
+		draw.FloydSteinberg.Draw(paletted, img.Bounds(), img, image.Point{})
+		anim.Image = append(anim.Image, paletted)
+		anim.Delay = append(anim.Delay, delay/10) // GIF的帧时长单位为10毫秒
+	}
+	// 中间的帧只显示当时的方向
+	view := opts.view(viewer)
+	replay := view
+	replay.Direction = ""
+	for i := range frames {
+		addFrame(renderMapImage(&frames[i], game.Background, replay))
+	}
+	addFrame(renderMapImage(&game.Map, game.Background, view))
+
+	// 最后一帧多停留一会儿
+	anim.Delay[len(anim.Delay)-1] = delay / 10 * 3
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/gif", nil
+}
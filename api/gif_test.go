@@ -0,0 +1,149 @@
+package api
+
+import (
+	"bytes"
+	"image/gif"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hoshinonyaruko/snake-in-im/config"
+	"github.com/hoshinonyaruko/snake-in-im/repository"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// readGIF 读取磁盘上保存的GIF渲染结果
+func readGIF(t *testing.T, url string) *gif.GIF {
+	t.Helper()
+	index := strings.Index(url, "/static/renders/")
+	if index < 0 {
+		t.Fatalf("gif_url %q is not a static render", url)
+	}
+	data, err := os.ReadFile("." + url[index:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	anim, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return anim
+}
+
+func TestReplayRecorderKeepsLatestFrames(t *testing.T) {
+	recorder := newReplayRecorder(3)
+	for tick := 1; tick <= 5; tick++ {
+		game := &structs.Game{Map: structs.GameMap{Width: tick, Snakes: map[string]structs.Snake{}}}
+		recorder.record(game)
+		// 记录的是副本，之后修改地图不影响已经记录的帧
+		game.Map.Width = 100
+	}
+	if len(recorder.frames) != 3 {
+		t.Fatalf("%d frames, want 3", len(recorder.frames))
+	}
+	for i, frame := range recorder.frames {
+		if frame.Width != i+3 {
+			t.Errorf("frame %d is tick %d, want %d", i, frame.Width, i+3)
+		}
+	}
+
+	if recorder := newReplayRecorder(0); recorder.maxFrames != 1 {
+		t.Errorf("max frames %d, want at least 1", recorder.maxFrames)
+	}
+}
+
+func TestRenderGIF(t *testing.T) {
+	game := crowdedGame("gif", 8, 8, 2, 3)
+	frame := game.Map
+
+	tests := []struct {
+		name   string
+		frames int
+		delay  int
+		want   []int // 每帧的时长，单位10毫秒
+	}{
+		{"no frames", 0, 200, []int{60}},
+		{"only the current map", 1, 200, []int{60}},
+		{"several frames", 4, 200, []int{20, 20, 20, 60}},
+		{"default delay", 2, 0, []int{config.GetConfigValue("gifframedelay").(int) / 10, config.GetConfigValue("gifframedelay").(int) / 10 * 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := RenderOptions{FrameDelay: tt.delay}
+			for i := 0; i < tt.frames; i++ {
+				opts.Frames = append(opts.Frames, frame)
+			}
+			data, contentType, err := renderGIF(game, "p0", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contentType != "image/gif" {
+				t.Errorf("content type %q", contentType)
+			}
+			anim, err := gif.DecodeAll(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(anim.Image) != len(tt.want) {
+				t.Fatalf("%d frames, want %d", len(anim.Image), len(tt.want))
+			}
+			for i, delay := range anim.Delay {
+				if delay != tt.want[i] {
+					t.Errorf("delays %v, want %v", anim.Delay, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestRenderMapHandlerGIF(t *testing.T) {
+	maxFrames := config.GetConfigValue("gifmaxframes").(int)
+	defaultDelay := config.GetConfigValue("gifframedelay").(int) / 10
+
+	tests := []struct {
+		name       string
+		ticks      int
+		query      string
+		wantFrames int
+		wantDelay  int
+	}{
+		// 刷新之前的地图加上每次刷新之后的地图
+		{"start and one frame per tick", 5, "&frame_delay=100", 6, 10},
+		{"max_frames keeps the latest", 5, "&max_frames=3&frame_delay=100", 3, 10},
+		{"max_frames is capped by the config", maxFrames + 5, "&max_frames=1000", maxFrames, defaultDelay},
+		{"invalid values use the defaults", 2, "&max_frames=-1&frame_delay=abc", 3, defaultDelay},
+		{"no due tick", 0, "&frame_delay=100", 1, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := repository.NewMemory()
+			router := newTestRouter(repo)
+			game := seedGame(t, repo, "gif", structs.Snake{OpenID: "alice", Direction: "right", Positions: []structs.Position{{X: 1, Y: 1}}})
+			game.LastRefresh -= int64(tt.ticks * game.RefreshInterval)
+			if err := repo.Save(game, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			code, body := doGet(t, router, "/render-map?groupid=gif&openid=alice&format=png&gif=1"+tt.query)
+			if code != http.StatusOK {
+				t.Fatalf("status %d: %v", code, body)
+			}
+			url, _ := body["gif_url"].(string)
+			anim := readGIF(t, url)
+			if len(anim.Image) != tt.wantFrames {
+				t.Fatalf("%d frames, want %d", len(anim.Image), tt.wantFrames)
+			}
+			for i, delay := range anim.Delay {
+				want := tt.wantDelay
+				if i == len(anim.Delay)-1 {
+					want *= 3
+				}
+				if delay != want {
+					t.Errorf("frame %d delay %d, want %d", i, delay, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package api
+
+import "sync"
+
+// groupLock 单个群组的锁，refs记录正在使用或等待的请求数
+type groupLock struct {
+	sync.Mutex
+	refs int
+}
+
+// groupLocker 为每个群组提供独立的互斥锁，同一群组的请求依次执行，不同群组互不阻塞
+type groupLocker struct {
+	mu    sync.Mutex
+	locks map[string]*groupLock
+}
+
+// 全局群组锁，覆盖 读取 → 刷新 → 绘图 → 保存 的整个过程
+var groupLocks = &groupLocker{locks: make(map[string]*groupLock)}
+
+// Lock 锁定群组并返回解锁函数，没有请求使用的锁会被回收
+func (l *groupLocker) Lock(groupID string) func() {
+	l.mu.Lock()
+	lock, ok := l.locks[groupID]
+	if !ok {
+		lock = &groupLock{}
+		l.locks[groupID] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, groupID)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hoshinonyaruko/snake-in-im/repository"
+	"github.com/hoshinonyaruko/snake-in-im/snake"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// tickingClock 每次读取时间都前进一秒，刷新间隔为1秒时每个请求至少执行一次刷新
+type tickingClock struct{ seconds atomic.Int64 }
+
+func (c *tickingClock) Now() time.Time {
+	return time.Unix(1700000000+c.seconds.Add(1), 0)
+}
+
+// checkingRepo 记录每次载入时的刷新次数，保存时检查期间没有其他请求保存过同一群组，
+// 并且每一次刷新只被执行一次，否则同一次刷新中蛇会移动两次
+type checkingRepo struct {
+	repository.GameRepository
+
+	mu     sync.Mutex
+	loaded map[*structs.Game]int64
+	saved  map[string]int64
+	ticks  map[string]map[int64]int
+	errors []string
+}
+
+func newCheckingRepo() *checkingRepo {
+	return &checkingRepo{
+		GameRepository: repository.NewMemory(),
+		loaded:         make(map[*structs.Game]int64),
+		saved:          make(map[string]int64),
+		ticks:          make(map[string]map[int64]int),
+	}
+}
+
+func (r *checkingRepo) Load(groupID string) (*structs.Game, error) {
+	game, err := r.GameRepository.Load(groupID)
+	if err == nil {
+		r.mu.Lock()
+		r.loaded[game] = game.Tick
+		r.mu.Unlock()
+	}
+	// 放大读取和保存之间的窗口，没有群组锁时并发的请求几乎一定会交错
+	time.Sleep(200 * time.Microsecond)
+	return game, err
+}
+
+func (r *checkingRepo) Create(game *structs.Game) error {
+	r.mu.Lock()
+	r.loaded[game] = game.Tick
+	r.saved[game.GroupID] = game.Tick
+	r.ticks[game.GroupID] = make(map[int64]int)
+	r.mu.Unlock()
+	return r.GameRepository.Create(game)
+}
+
+func (r *checkingRepo) Save(game *structs.Game, deaths []structs.Death) error {
+	r.mu.Lock()
+	from, ok := r.loaded[game]
+	if !ok {
+		r.errors = append(r.errors, fmt.Sprintf("%s: saved a game that was not loaded", game.GroupID))
+	} else if stored := r.saved[game.GroupID]; stored != from {
+		r.errors = append(r.errors, fmt.Sprintf("%s: loaded at tick %d but tick %d was saved in between", game.GroupID, from, stored))
+	}
+	for tick := from + 1; tick <= game.Tick; tick++ {
+		r.ticks[game.GroupID][tick]++
+		if r.ticks[game.GroupID][tick] > 1 {
+			r.errors = append(r.errors, fmt.Sprintf("%s: tick %d executed twice", game.GroupID, tick))
+		}
+	}
+	r.saved[game.GroupID] = game.Tick
+	delete(r.loaded, game)
+	r.mu.Unlock()
+	return r.GameRepository.Save(game, deaths)
+}
+
+func TestConcurrentRequestsAcrossGroups(t *testing.T) {
+	previous := snake.DefaultEngine
+	snake.DefaultEngine = snake.NewEngine(&tickingClock{})
+	defer func() { snake.DefaultEngine = previous }()
+
+	repo := newCheckingRepo()
+	router := newTestRouter(repo)
+
+	const groups, workers, requests = 4, 8, 12
+	directions := []string{"up", "left", "down", "right"}
+	var wg sync.WaitGroup
+	for g := 0; g < groups; g++ {
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(groupID, openID string, w int) {
+				defer wg.Done()
+				for i := 0; i < requests; i++ {
+					var target string
+					switch i % 4 {
+					case 0, 1:
+						target = fmt.Sprintf("/render-map?groupid=%s&openid=%s&mode=text&refresh_interval=1&width=16&height=16&edges=solid", groupID, openID)
+						if i%8 == 0 {
+							target += "&foodname=food"
+						}
+					case 2:
+						target = fmt.Sprintf("/update-direction?groupid=%s&openid=%s&direction=%s", groupID, openID, directions[(w+i)%len(directions)])
+					case 3:
+						target = "/state?groupid=" + groupID
+					}
+					code, body := doGet(t, router, target)
+					// 蛇被淘汰后修改方向返回错误，其他请求必须成功
+					if code != http.StatusOK && i%4 != 2 && !(i%4 == 3 && code == http.StatusNotFound) {
+						t.Errorf("GET %s: %d %v", target, code, body)
+					}
+				}
+			}(fmt.Sprintf("group-%d", g), fmt.Sprintf("player-%d", w), w)
+		}
+	}
+	wg.Wait()
+
+	for _, err := range repo.errors {
+		t.Error(err)
+	}
+
+	for g := 0; g < groups; g++ {
+		groupID := fmt.Sprintf("group-%d", g)
+		game, err := repo.GameRepository.Load(groupID)
+		if err != nil {
+			t.Fatalf("%s: %v", groupID, err)
+		}
+		if game.Tick == 0 {
+			t.Errorf("%s: no tick was executed", groupID)
+		}
+		for tick := int64(1); tick <= game.Tick; tick++ {
+			if n := repo.ticks[groupID][tick]; n != 1 {
+				t.Errorf("%s: tick %d executed %d times", groupID, tick, n)
+			}
+		}
+
+		// 按输入记录回放必须得到同样的地图，并发的请求没有丢失或重复任何输入
+		inputs, err := repo.Inputs(groupID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		replay := snake.DefaultEngine.Replay(game, inputs, game.Tick)
+		want, _ := json.Marshal(game.Map)
+		got, _ := json.Marshal(replay.Map)
+		if string(want) != string(got) {
+			t.Errorf("%s: replay differs from the saved game\nsaved:  %s\nreplay: %s", groupID, want, got)
+		}
+	}
+}
+
+func TestGroupLockSerializesSameGroup(t *testing.T) {
+	var inside, maxInside atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			unlock := groupLocks.Lock(fmt.Sprintf("lock-%d", i%2))
+			defer unlock()
+			n := inside.Add(1)
+			for {
+				m := maxInside.Load()
+				if n <= m || maxInside.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			inside.Add(-1)
+		}(i)
+	}
+	wg.Wait()
+
+	// 两个群组各自串行，最多同时有两个请求持有锁
+	if m := maxInside.Load(); m > 2 {
+		t.Fatalf("%d requests held group locks at once, want at most 2", m)
+	}
+	groupLocks.mu.Lock()
+	defer groupLocks.mu.Unlock()
+	if len(groupLocks.locks) != 0 {
+		t.Fatalf("%d group locks were not released", len(groupLocks.locks))
+	}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hoshinonyaruko/snake-in-im/config"
+	"github.com/hoshinonyaruko/snake-in-im/repository"
+)
+
+// TestMain 在临时目录中运行测试，渲染结果和配置文件不会写入源码目录
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "snake-api-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	config.LoadConfig(filepath.Join(dir, "config.json"))
+	gin.SetMode(gin.TestMode)
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestRouter 注册与main.go相同的接口
+func newTestRouter(repo repository.GameRepository) *gin.Engine {
+	router := gin.New()
+	router.GET("/update-direction", UpdateDirection(repo))
+	router.GET("/render-map", RenderMapHandler(repo))
+	router.GET("/delete-map", DeleteMapHandler(repo))
+	router.GET("/spawn-food", SpawnFoodHandler(repo))
+	router.GET("/state", StateHandler(repo))
+	router.GET("/deaths", DeathsHandler(repo))
+	router.GET("/renders/:group/:name", RendersHandler())
+	return router
+}
+
+// doGet 发送GET请求，返回状态码和解析后的JSON
+func doGet(t testing.TB, router http.Handler, target string) (int, map[string]any) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+	var body map[string]any
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Errorf("GET %s: invalid JSON %q: %v", target, recorder.Body.String(), err)
+	}
+	return recorder.Code, body
+}
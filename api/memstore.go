@@ -0,0 +1,100 @@
+package api
+
+import (
+	"container/list"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hoshinonyaruko/snake-in-im/config"
+)
+
+// renderEntry 内存中的一次渲染结果
+type renderEntry struct {
+	key         string
+	contentType string
+	data        []byte
+}
+
+// renderCache 按条数和总字节数限制的LRU，保存最近的渲染结果
+type renderCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int
+	bytes      int
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+// 全局渲染结果缓存，容量在第一次使用时从配置读取
+var renders = &renderCache{ll: list.New(), items: make(map[string]*list.Element)}
+
+// limits 读取容量配置，不大于0表示不限制
+func (c *renderCache) limits() {
+	if c.maxEntries == 0 && c.maxBytes == 0 {
+		c.maxEntries = config.GetConfigValue("rendercacheentries").(int)
+		c.maxBytes = config.GetConfigValue("rendercachebytes").(int)
+	}
+}
+
+// Put 保存渲染结果，超出容量时淘汰最久未访问的结果
+func (c *renderCache) Put(key, contentType string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limits()
+
+	if elem, ok := c.items[key]; ok {
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&renderEntry{key: key, contentType: contentType, data: data})
+	c.bytes += len(data)
+
+	for c.ll.Len() > 1 && ((c.maxEntries > 0 && c.ll.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes)) {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// Get 读取渲染结果并标记为最近访问
+func (c *renderCache) Get(key string) (*renderEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return elem.Value.(*renderEntry), true
+}
+
+// RemovePrefix 删除所有以prefix开头的结果
+func (c *renderCache) RemovePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, elem := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.removeElement(elem)
+		}
+	}
+}
+
+func (c *renderCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*renderEntry)
+	c.ll.Remove(elem)
+	delete(c.items, entry.key)
+	c.bytes -= len(entry.data)
+}
+
+// RendersHandler 从内存提供渲染结果，文件名包含内容哈希，内容不会改变
+func RendersHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		entry, ok := renders.Get(c.Param("group") + "/" + c.Param("name"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Render not found or expired"})
+			return
+		}
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+		c.Data(http.StatusOK, entry.contentType, entry.data)
+	}
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"sync"
+	"testing"
+
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// crowdedGame 在width×height的地图上横向排列多条长蛇，每行之间留一行空隙，排满后向右错开
+func crowdedGame(groupID string, width, height, snakes, length int) *structs.Game {
+	game := &structs.Game{
+		GroupID:    groupID,
+		Background: "color:#203040",
+		Map: structs.GameMap{
+			Snakes: make(map[string]structs.Snake),
+			Width:  width,
+			Height: height,
+		},
+	}
+	for i := 0; i < snakes; i++ {
+		openID := fmt.Sprintf("p%d", i)
+		y := (i * 2) % height
+		x := (i / (height / 2)) * (length + 1)
+		s := structs.Snake{OpenID: openID, Direction: "right"}
+		for j := 0; j < length; j++ {
+			avatar := openID + "_blur_small.jpg"
+			if j == 0 {
+				avatar = openID + "_small.jpg"
+			}
+			s.Positions = append(s.Positions, structs.Position{X: (x + length - j + width) % width, Y: y, Avatar: avatar})
+		}
+		game.Map.Snakes[openID] = s
+	}
+	for i := 0; i < 50; i++ {
+		game.Map.Food = append(game.Map.Food, structs.Position{X: (i * 37) % width, Y: (i*2 + 1) % height, Avatar: "food_small.png", Food: "food"})
+	}
+	return game
+}
+
+func BenchmarkRenderMapImage100x100(b *testing.B) {
+	game := crowdedGame("bench", 100, 100, 40, 12)
+	view := RenderOptions{Highlight: true, SnakeStyle: snakeStyleSprites, AvatarHead: true}.view("p0")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		renderMapImage(&game.Map, game.Background, view)
+	}
+}
+
+// TestRenderParallel 多个群组同时渲染，共用精灵图、背景和渲染结果的缓存
+// 并发渲染的结果必须与单独渲染的结果相同，配合-race检查缓存的并发访问
+func TestRenderParallel(t *testing.T) {
+	backgroundsUsed := []string{"color:#203040", "color:#405060", "avatar:p1"}
+	styles := []string{snakeStyleSprites, snakeStyleBlocks}
+
+	render := func(i int) []byte {
+		game := crowdedGame(fmt.Sprintf("parallel-%d", i%4), 12, 12, 6, 4)
+		game.Background = backgroundsUsed[i%len(backgroundsUsed)]
+		opts := RenderOptions{Highlight: true, SnakeStyle: styles[i%len(styles)], AvatarHead: true, Format: "png"}
+		data, _, err := renderRaster(game, fmt.Sprintf("p%d", i%8), opts)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		return data
+	}
+
+	const workers, rounds = 8, 4
+	want := make([][]byte, workers)
+	for i := range want {
+		want[i] = render(i)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				data := render(w)
+				if !bytes.Equal(data, want[w]) {
+					t.Errorf("worker %d round %d: parallel render differs from serial render", w, r)
+				}
+				if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+					t.Errorf("worker %d: invalid png: %v", w, err)
+				}
+
+				// 渲染结果的内存缓存
+				key := fmt.Sprintf("parallel/%d-%d.png", w, r)
+				renders.Put(key, "image/png", data)
+				if entry, ok := renders.Get(key); ok && !bytes.Equal(entry.data, data) {
+					t.Errorf("render cache returned different data for %s", key)
+				}
+
+				// 头像更新和渲染同时发生，失效的背景重新绘制
+				if r%2 == 1 {
+					InvalidateAvatarBackground("p1")
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	renders.RemovePrefix("parallel/")
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"fmt"
+	"mime"
+	"sort"
+	"sync"
+
+	"github.com/hoshinonyaruko/snake-in-im/config"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+	"github.com/hoshinonyaruko/snake-in-im/theme"
+)
+
+// Renderer 把游戏画面渲染为某种格式的数据，返回数据和Content-Type
+// 不同群组的请求会同时调用同一个渲染器，实现需要能并发使用
+type Renderer interface {
+	Render(game *structs.Game, viewer string, opts RenderOptions) ([]byte, string, error)
+}
+
+// RendererFunc 把普通函数作为Renderer使用
+type RendererFunc func(game *structs.Game, viewer string, opts RenderOptions) ([]byte, string, error)
+
+func (f RendererFunc) Render(game *structs.Game, viewer string, opts RenderOptions) ([]byte, string, error) {
+	return f(game, viewer, opts)
+}
+
+// RenderOptions 一次渲染的参数，各渲染器只使用自己需要的部分
+type RenderOptions struct {
+	Direction  string               // 观看者本次设置的方向，为空时只查看
+	Highlight  bool                 // 突出显示观看者的蛇
+	Scoreboard string               // 排行榜位置，见scoreboardNone等
+	Ranking    []structs.SnakeState // 按排名排列的蛇，用于排行榜
+	Format     string               // 图片格式，见imageFormats
+	Quality    int                  // 有损格式的质量，1-100
+	MaxBytes   int                  // 体积上限，0表示不限制
+	TextStyle  string               // 文字地图的字符风格，见textglyphs配置
+	Frames     []structs.GameMap    // 本次经过的每一帧，用于回放
+	FrameDelay int                  // 回放每帧的时长，毫秒
+	SnakeStyle string               // 蛇的画法，见snakeStyleBlocks等
+	AvatarHead bool                 // 使用精灵图时是否在蛇头上画出玩家头像
+	Theme      *theme.Theme         // 棋盘的外观，为空时使用默认主题
+
+	viewport viewport
+}
+
+// Window 返回视口内的地图，坐标已经换算到视口内，没有视口时返回整张地图
+func (o RenderOptions) Window(game *structs.Game, viewer string) *structs.GameMap {
+	return o.viewport.window(&game.Map, viewer).apply(&game.Map)
+}
+
+// view 返回与观看者有关的渲染选项
+func (o RenderOptions) view(viewer string) renderView {
+	return renderView{
+		Viewer:     viewer,
+		Direction:  o.Direction,
+		Highlight:  o.Highlight,
+		Viewport:   o.viewport,
+		Style:      o.SnakeStyle,
+		AvatarHead: o.AvatarHead,
+		Theme:      o.theme(),
+	}
+}
+
+// theme 返回使用的主题
+func (o RenderOptions) theme() *theme.Theme {
+	if o.Theme == nil {
+		return theme.Default()
+	}
+	return o.Theme
+}
+
+// encode 返回图片编码参数
+func (o RenderOptions) encode() encodeOptions {
+	return encodeOptions{Format: o.Format, Quality: o.Quality, MaxBytes: o.MaxBytes}
+}
+
+// 内置的渲染器
+const (
+	rendererRaster = "raster" // 位图，格式由format参数决定
+	rendererGIF    = "gif"    // GIF回放
+	rendererText   = "text"   // 文字地图
+)
+
+var (
+	renderersMu sync.RWMutex
+	renderers   = make(map[string]Renderer)
+)
+
+func init() {
+	RegisterRenderer(rendererRaster, RendererFunc(renderRaster))
+	RegisterRenderer(rendererGIF, RendererFunc(renderGIF))
+	RegisterRenderer(rendererText, RendererFunc(renderTextFile))
+}
+
+// RegisterRenderer 注册一个渲染器，之后可以通过renderer参数或群组设置选择
+// 同名的渲染器会被替换，可以用来替换内置的渲染器
+func RegisterRenderer(name string, r Renderer) {
+	renderersMu.Lock()
+	defer renderersMu.Unlock()
+	renderers[name] = r
+}
+
+// Renderers 返回已注册的渲染器名称
+func Renderers() []string {
+	renderersMu.RLock()
+	defer renderersMu.RUnlock()
+	names := make([]string, 0, len(renderers))
+	for name := range renderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupRenderer 按名称查找渲染器
+func lookupRenderer(name string) (Renderer, error) {
+	renderersMu.RLock()
+	defer renderersMu.RUnlock()
+	r, ok := renderers[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported renderer %q", name)
+	}
+	return r, nil
+}
+
+// groupRenderer 返回本次请求使用的渲染器名称
+// 依次使用请求参数、群组设置和配置，都为空时使用位图
+func groupRenderer(requested string, game *structs.Game) string {
+	if requested != "" {
+		return requested
+	}
+	if game.Renderer != "" {
+		return game.Renderer
+	}
+	if name := config.GetConfigValue("renderer").(string); name != "" {
+		return name
+	}
+	return rendererRaster
+}
+
+// groupTheme 返回本次请求使用的主题
+// 依次使用请求参数、群组设置和配置，主题不存在时使用默认主题
+func groupTheme(requested string, game *structs.Game) *theme.Theme {
+	for _, name := range []string{requested, game.Theme, config.GetConfigValue("theme").(string)} {
+		if name == "" {
+			continue
+		}
+		if th, ok := theme.Get(name); ok {
+			return th
+		}
+	}
+	return theme.Default()
+}
+
+// renderAndSave 使用指定的渲染器渲染并保存，返回访问路径和Content-Type
+func renderAndSave(name string, game *structs.Game, viewer string, opts RenderOptions) (string, string, error) {
+	r, err := lookupRenderer(name)
+	if err != nil {
+		return "", "", err
+	}
+	data, contentType, err := r.Render(game, viewer, opts)
+	if err != nil {
+		return "", contentType, err
+	}
+
+	// 保存结果，每次渲染使用独立的文件名
+	urlPath, err := storeRender(game.GroupID, game.Tick, viewer, data, contentType)
+	return urlPath, contentType, err
+}
+
+// renderRaster 渲染位图，按需要加上排行榜并按指定格式编码
+func renderRaster(game *structs.Game, viewer string, opts RenderOptions) ([]byte, string, error) {
+	img := renderMapImage(&game.Map, game.Background, opts.view(viewer))
+	img = addScoreboard(img, opts.Ranking, opts.Scoreboard, config.GetConfigValue("blocksize").(int), opts.theme())
+
+	data, format, err := encodeImage(img, opts.encode())
+	return data, format.ContentType, err
+}
+
+// renderTextFile 把文字地图和图例保存为纯文本
+func renderTextFile(game *structs.Game, viewer string, opts RenderOptions) ([]byte, string, error) {
+	glyphs, err := lookupTextGlyphs(opts.TextStyle)
+	if err != nil {
+		return nil, "", err
+	}
+	text, legend := renderText(game, opts.view(viewer), glyphs)
+	return []byte(text + "\n\n" + legend + "\n"), "text/plain; charset=utf-8", nil
+}
+
+// 非图片格式的扩展名
+var renderExtensions = map[string]string{
+	"image/gif":     ".gif",
+	"image/svg+xml": ".svg",
+	"text/plain":    ".txt",
+}
+
+// extensionFor 根据Content-Type选择保存时使用的扩展名
+func extensionFor(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ".bin"
+	}
+	for _, format := range imageFormats {
+		if format.ContentType == mediaType {
+			return format.Ext
+		}
+	}
+	if ext, ok := renderExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/disintegration/imaging"
+	"github.com/fogleman/gg"
+	"github.com/hoshinonyaruko/snake-in-im/memimg"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+	"github.com/hoshinonyaruko/snake-in-im/theme"
+)
+
+// 排行榜的位置
+const (
+	scoreboardNone = "none" // 不显示
+	scoreboardSide = "side" // 地图右侧
+	scoreboardTop  = "top"  // 地图上方
+)
+
+const (
+	scoreboardPadding   = 8  // 排行榜内边距
+	scoreboardMaxName   = 10 // 昵称最多显示的字符数
+	scoreboardTopRows   = 5  // 上方排行榜最多显示的行数
+	scoreboardMinLine   = 18 // 每行的最小高度
+	scoreboardMinHeight = 10 // 右侧排行榜至少能显示的行数
+)
+
+// validScoreboard 检查排行榜位置
+func validScoreboard(layout string) bool {
+	return layout == scoreboardNone || layout == scoreboardSide || layout == scoreboardTop
+}
+
+// displayName 返回蛇在排行榜上的名称，没有昵称时使用OpenID，过长时截断
+func displayName(s structs.SnakeState) string {
+	name := s.Nickname
+	if name == "" {
+		name = s.OpenID
+	}
+	runes := []rune(name)
+	if len(runes) > scoreboardMaxName {
+		name = string(runes[:scoreboardMaxName-1]) + "…"
+	}
+	return name
+}
+
+// addScoreboard 在地图旁边加上排行榜，显示蛇头头像、昵称、长度、击杀数和排名
+// snakes需要已经按排名排列，layout为none时原样返回地图，颜色和字体由主题决定
+func addScoreboard(board image.Image, snakes []structs.SnakeState, layout string, blockSize int, th *theme.Theme) image.Image {
+	if layout != scoreboardSide && layout != scoreboardTop {
+		return board
+	}
+
+	lineHeight := blockSize
+	if lineHeight < scoreboardMinLine {
+		lineHeight = scoreboardMinLine
+	}
+	iconSize := lineHeight - 4
+
+	// 计算每一行的文字和最大宽度
+	face := th.FontFace()
+	measure := gg.NewContext(1, 1)
+	measure.SetFontFace(face)
+	title := "排行榜"
+	lines := make([]string, len(snakes))
+	textWidth, _ := measure.MeasureString(title)
+	for i, s := range snakes {
+		lines[i] = fmt.Sprintf("%d. %s  长度%d  击杀%d  得分%d", s.Rank, displayName(s), s.Length, s.Kills, s.Score)
+		if w, _ := measure.MeasureString(lines[i]); w > textWidth {
+			textWidth = w
+		}
+	}
+	contentWidth := scoreboardPadding*2 + iconSize + 6 + int(textWidth)
+
+	boardWidth := board.Bounds().Dx()
+	boardHeight := board.Bounds().Dy()
+	var width, height, panelX, panelY, panelWidth, panelHeight, boardX, boardY, rows int
+	if layout == scoreboardSide {
+		rows = len(snakes)
+		minRows := (boardHeight-scoreboardPadding*2)/lineHeight - 1
+		if minRows < scoreboardMinHeight {
+			minRows = scoreboardMinHeight
+		}
+		if rows > minRows {
+			rows = minRows
+		}
+		panelWidth = contentWidth
+		panelHeight = scoreboardPadding*2 + (rows+1)*lineHeight
+		if panelHeight < boardHeight {
+			panelHeight = boardHeight
+		}
+		width, height = boardWidth+panelWidth, panelHeight
+		panelX = boardWidth
+	} else {
+		rows = len(snakes)
+		if rows > scoreboardTopRows {
+			rows = scoreboardTopRows
+		}
+		panelWidth = boardWidth
+		if contentWidth > panelWidth {
+			panelWidth = contentWidth
+		}
+		panelHeight = scoreboardPadding*2 + (rows+1)*lineHeight
+		width, height = panelWidth, panelHeight+boardHeight
+		boardY = panelHeight
+	}
+
+	dc := gg.NewContext(width, height)
+	dc.SetColor(th.Scoreboard.Background)
+	dc.Clear()
+	dc.DrawImage(board, boardX, boardY)
+	dc.SetFontFace(face)
+
+	// 标题
+	x := float64(panelX + scoreboardPadding)
+	y := float64(panelY + scoreboardPadding)
+	dc.SetColor(th.Scoreboard.Title)
+	dc.DrawStringAnchored(title, x, y+float64(lineHeight)/2, 0, 0.5)
+
+	for i := 0; i < rows; i++ {
+		s := snakes[i]
+		rowY := y + float64((i+1)*lineHeight)
+
+		// 蛇头头像，方便对照地图找到对应的蛇
+		icon, found := memimg.GetAvatarFromMemory(s.Head.Avatar)
+		if found {
+			dc.DrawImage(imaging.Resize(icon, iconSize, iconSize, imaging.Lanczos), int(x), int(rowY)+2)
+		} else {
+			dc.SetColor(th.Missing)
+			dc.DrawRectangle(x, rowY+2, float64(iconSize), float64(iconSize))
+			dc.Fill()
+		}
+
+		dc.SetColor(th.Scoreboard.Text)
+		dc.DrawStringAnchored(lines[i], x+float64(iconSize+6), rowY+float64(lineHeight)/2, 0, 0.5)
+	}
+
+	return dc.Image()
+}
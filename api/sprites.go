@@ -0,0 +1,326 @@
+package api
+
+import (
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/disintegration/imaging"
+	"github.com/fogleman/gg"
+	"github.com/hoshinonyaruko/snake-in-im/memimg"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+	"github.com/hoshinonyaruko/snake-in-im/theme"
+)
+
+// 蛇的画法
+const (
+	snakeStyleBlocks  = "blocks"  // 每一节画一张头像或食物图片
+	snakeStyleSprites = "sprites" // 使用精灵图画出相连的身体
+)
+
+// validSnakeStyle 检查蛇的画法
+func validSnakeStyle(style string) bool {
+	return style == snakeStyleBlocks || style == snakeStyleSprites
+}
+
+// 精灵图中的部件，按此顺序从左到右排列，每个部件是一个正方形
+// 头朝上、脖子连接下方；身体竖直；拐角连接下方和右方；尾巴连接上方、向下变细
+const (
+	spriteHead = iota
+	spriteBody
+	spriteCorner
+	spriteTail
+	spriteParts
+)
+
+// spriteSheet 缩放到格子大小的精灵图，以及按颜色和方向处理过的缓存
+type spriteSheet struct {
+	mu     sync.Mutex
+	source image.Image // 原始的精灵图，为空时使用内置的精灵图
+	size   int
+	parts  [spriteParts]image.Image
+	cache  map[string]image.Image
+}
+
+// 全局精灵图，LoadSprites之前使用内置的精灵图
+var sprites = &spriteSheet{cache: make(map[string]image.Image)}
+
+// 主题自带的精灵图，按主题名称保存，主题更新后按原始图片重新生成
+var (
+	themeSpritesMutex sync.Mutex
+	themeSprites      = make(map[string]*spriteSheet)
+)
+
+// spritesFor 返回主题使用的精灵图，主题没有精灵图时使用全局的精灵图
+func spritesFor(th *theme.Theme) *spriteSheet {
+	if th.Sprites == nil {
+		return sprites
+	}
+	themeSpritesMutex.Lock()
+	defer themeSpritesMutex.Unlock()
+	sh, ok := themeSprites[th.Name]
+	if !ok || sh.source != th.Sprites {
+		sh = &spriteSheet{source: th.Sprites, cache: make(map[string]image.Image)}
+		themeSprites[th.Name] = sh
+	}
+	return sh
+}
+
+// LoadSprites 从文件载入精灵图并缩放到blockSize，文件不存在或无效时使用内置的精灵图
+// 精灵图从左到右依次为头、身体、拐角、尾巴，部件使用白色或灰色，绘制时按蛇的颜色着色
+func LoadSprites(path string, blockSize int) {
+	sheet, err := imaging.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to load sprite sheet %s, using built-in sprites: %v", path, err)
+		}
+		sprites.set(nil, builtinSpriteParts(blockSize), blockSize)
+		return
+	}
+	parts, err := splitSpriteSheet(sheet, blockSize)
+	if err != nil {
+		log.Printf("Failed to load sprite sheet %s, using built-in sprites: %v", path, err)
+		sprites.set(nil, builtinSpriteParts(blockSize), blockSize)
+		return
+	}
+	sprites.set(sheet, parts, blockSize)
+}
+
+// splitSpriteSheet 把精灵图切分为部件并缩放到blockSize
+func splitSpriteSheet(sheet image.Image, blockSize int) ([spriteParts]image.Image, error) {
+	var parts [spriteParts]image.Image
+	cell := sheet.Bounds().Dy()
+	if cell == 0 || sheet.Bounds().Dx() < cell*spriteParts {
+		return parts, fmt.Errorf("sprite sheet must contain %d square parts side by side", spriteParts)
+	}
+	for i := range parts {
+		part := imaging.Crop(sheet, image.Rect(i*cell, 0, (i+1)*cell, cell).Add(sheet.Bounds().Min))
+		parts[i] = imaging.Resize(part, blockSize, blockSize, imaging.Lanczos)
+	}
+	return parts, nil
+}
+
+// builtinSpriteParts 绘制内置的精灵图
+func builtinSpriteParts(size int) [spriteParts]image.Image {
+	s := float64(size)
+	var parts [spriteParts]image.Image
+	draw := func(fn func(dc *gg.Context)) image.Image {
+		dc := gg.NewContext(size, size)
+		dc.SetRGB(1, 1, 1)
+		fn(dc)
+		return dc.Image()
+	}
+
+	parts[spriteHead] = draw(func(dc *gg.Context) {
+		dc.DrawRectangle(s*0.2, s*0.5, s*0.6, s*0.5)
+		dc.DrawEllipse(s*0.5, s*0.45, s*0.42, s*0.45)
+		dc.Fill()
+		dc.SetRGB(0, 0, 0)
+		dc.DrawCircle(s*0.33, s*0.3, s*0.08)
+		dc.DrawCircle(s*0.67, s*0.3, s*0.08)
+		dc.Fill()
+	})
+	parts[spriteBody] = draw(func(dc *gg.Context) {
+		dc.DrawRectangle(s*0.2, 0, s*0.6, s)
+		dc.Fill()
+		dc.SetRGB(0.8, 0.8, 0.8)
+		dc.DrawEllipse(s*0.5, s*0.5, s*0.18, s*0.25)
+		dc.Fill()
+	})
+	parts[spriteCorner] = draw(func(dc *gg.Context) {
+		dc.DrawRectangle(s*0.2, s*0.5, s*0.6, s*0.5)
+		dc.DrawRectangle(s*0.5, s*0.2, s*0.5, s*0.6)
+		dc.DrawCircle(s*0.5, s*0.5, s*0.3)
+		dc.Fill()
+	})
+	parts[spriteTail] = draw(func(dc *gg.Context) {
+		dc.MoveTo(s*0.2, 0)
+		dc.LineTo(s*0.8, 0)
+		dc.LineTo(s*0.5, s*0.9)
+		dc.ClosePath()
+		dc.Fill()
+	})
+	return parts
+}
+
+func (sh *spriteSheet) set(source image.Image, parts [spriteParts]image.Image, size int) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.source = source
+	sh.parts = parts
+	sh.size = size
+	sh.cache = make(map[string]image.Image)
+}
+
+// get 返回着色并顺时针旋转turns个90度之后的部件
+func (sh *spriteSheet) get(part int, tint color.NRGBA, turns int, size int) image.Image {
+	key := fmt.Sprintf("%d|%02x%02x%02x|%d|%d", part, tint.R, tint.G, tint.B, turns%4, size)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if img, ok := sh.cache[key]; ok {
+		return img
+	}
+	if sh.size != size || sh.parts[part] == nil {
+		// 格子大小变化时从原始的精灵图重新缩放
+		parts := builtinSpriteParts(size)
+		if sh.source != nil {
+			if scaled, err := splitSpriteSheet(sh.source, size); err == nil {
+				parts = scaled
+			}
+		}
+		sh.parts = parts
+		sh.size = size
+		sh.cache = make(map[string]image.Image)
+	}
+
+	// 按颜色相乘，白色变为蛇的颜色，黑色保持不变
+	img := imaging.AdjustFunc(sh.parts[part], func(c color.NRGBA) color.NRGBA {
+		return color.NRGBA{
+			R: uint8(uint16(c.R) * uint16(tint.R) / 255),
+			G: uint8(uint16(c.G) * uint16(tint.G) / 255),
+			B: uint8(uint16(c.B) * uint16(tint.B) / 255),
+			A: c.A,
+		}
+	})
+	switch turns % 4 {
+	case 1:
+		img = imaging.Rotate270(img) // imaging的Rotate为逆时针
+	case 2:
+		img = imaging.Rotate180(img)
+	case 3:
+		img = imaging.Rotate90(img)
+	}
+	sh.cache[key] = img
+	return img
+}
+
+// snakeColor 从主题的调色板中返回蛇的颜色，按OpenID固定分配，同一条蛇在所有画面中颜色相同
+func snakeColor(openID string, palette []color.NRGBA) color.NRGBA {
+	h := fnv.New32a()
+	h.Write([]byte(openID))
+	return palette[h.Sum32()%uint32(len(palette))]
+}
+
+// 方向按顺时针排列，下标即为从朝上开始顺时针旋转的次数
+var clockwise = []string{"up", "right", "down", "left"}
+
+func turnsFor(direction string) int {
+	for i, d := range clockwise {
+		if d == direction {
+			return i
+		}
+	}
+	return 0
+}
+
+// neighbourDirection 返回从a到相邻格子b的方向，考虑地图边缘相连，不相邻时返回空
+func neighbourDirection(a, b structs.Position) string {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	// 跨越边缘时差值为地图宽或高减一
+	if dx > 1 {
+		dx = -1
+	} else if dx < -1 {
+		dx = 1
+	}
+	if dy > 1 {
+		dy = -1
+	} else if dy < -1 {
+		dy = 1
+	}
+	switch {
+	case dx == 0 && dy == -1:
+		return "up"
+	case dx == 1 && dy == 0:
+		return "right"
+	case dx == 0 && dy == 1:
+		return "down"
+	case dx == -1 && dy == 0:
+		return "left"
+	}
+	return ""
+}
+
+// spritePlacement 一个格子上要画的精灵，Key用于在SVG中复用同一张图片
+type spritePlacement struct {
+	Pos structs.Position
+	Img image.Image
+	Key string
+}
+
+// snakeSprites 计算一条蛇每一节使用的精灵
+// 蛇头朝向direction，身体根据前后两节选择直线或拐角，尾巴朝向前一节
+func snakeSprites(s structs.Snake, blockSize int, direction string, th *theme.Theme) []spritePlacement {
+	sheet := spritesFor(th)
+	tint := snakeColor(s.OpenID, th.Palette)
+	placements := make([]spritePlacement, 0, len(s.Positions))
+	add := func(pos structs.Position, part, turns int) {
+		placements = append(placements, spritePlacement{
+			Pos: pos,
+			Img: sheet.get(part, tint, turns, blockSize),
+			Key: fmt.Sprintf("sprite:%d:%02x%02x%02x:%d", part, tint.R, tint.G, tint.B, turns),
+		})
+	}
+
+	for i, pos := range s.Positions {
+		switch {
+		case i == 0:
+			add(pos, spriteHead, turnsFor(direction))
+		case i == len(s.Positions)-1:
+			// 尾巴的部件连接上方
+			toPrev := neighbourDirection(pos, s.Positions[i-1])
+			if toPrev == "" {
+				toPrev = direction
+			}
+			add(pos, spriteTail, turnsFor(toPrev))
+		default:
+			toPrev := neighbourDirection(pos, s.Positions[i-1])
+			toNext := neighbourDirection(pos, s.Positions[i+1])
+			if toPrev == "" || toNext == "" {
+				add(pos, spriteBody, turnsFor(direction))
+				continue
+			}
+			a, b := turnsFor(toPrev), turnsFor(toNext)
+			if (a-b+4)%4 == 2 {
+				// 直线，竖直的部件旋转0次或1次
+				add(pos, spriteBody, a%2)
+				continue
+			}
+			// 拐角的部件连接右方(1)和下方(2)，旋转后连接的两个方向为turns+1和turns+2
+			first := a
+			if (b-a+4)%4 == 3 {
+				first = b
+			}
+			add(pos, spriteCorner, (first-1+4)%4)
+		}
+	}
+	return placements
+}
+
+// drawSpriteSnake 用精灵图画一条蛇，需要时把玩家头像以圆形画在蛇头上
+func drawSpriteSnake(dc *gg.Context, s structs.Snake, blockSize int, direction string, avatarHead bool, th *theme.Theme) {
+	placements := snakeSprites(s, blockSize, direction, th)
+	// 从尾部画到头部，蛇头在最上层
+	for i := len(placements) - 1; i >= 0; i-- {
+		p := placements[i]
+		dc.DrawImage(p.Img, p.Pos.X*blockSize, p.Pos.Y*blockSize)
+	}
+	if !avatarHead || len(s.Positions) == 0 {
+		return
+	}
+	head := s.Positions[0]
+	avatar, found := memimg.GetAvatarFromMemory(head.Avatar)
+	if !found {
+		return
+	}
+	size := blockSize * 3 / 5
+	cx := float64(head.X*blockSize) + float64(blockSize)/2
+	cy := float64(head.Y*blockSize) + float64(blockSize)/2
+	dc.DrawCircle(cx, cy, float64(size)/2)
+	dc.Clip()
+	dc.DrawImageAnchored(imaging.Resize(avatar, size, size, imaging.Lanczos), int(cx), int(cy), 0.5, 0.5)
+	dc.ResetClip()
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+	"github.com/hoshinonyaruko/snake-in-im/theme"
+)
+
+// placedPart 从精灵的Key中取出部件和旋转次数
+func placedPart(t *testing.T, p spritePlacement) [2]int {
+	t.Helper()
+	fields := strings.Split(p.Key, ":")
+	if len(fields) != 4 || fields[0] != "sprite" {
+		t.Fatalf("unexpected sprite key %q", p.Key)
+	}
+	part, err1 := strconv.Atoi(fields[1])
+	turns, err2 := strconv.Atoi(fields[3])
+	if err1 != nil || err2 != nil {
+		t.Fatalf("unexpected sprite key %q", p.Key)
+	}
+	return [2]int{part, turns}
+}
+
+func TestNeighbourDirection(t *testing.T) {
+	tests := []struct {
+		a, b structs.Position
+		want string
+	}{
+		{structs.Position{X: 5, Y: 5}, structs.Position{X: 5, Y: 4}, "up"},
+		{structs.Position{X: 5, Y: 5}, structs.Position{X: 6, Y: 5}, "right"},
+		{structs.Position{X: 5, Y: 5}, structs.Position{X: 5, Y: 6}, "down"},
+		{structs.Position{X: 5, Y: 5}, structs.Position{X: 4, Y: 5}, "left"},
+		// 跨过地图边缘
+		{structs.Position{X: 0, Y: 5}, structs.Position{X: 9, Y: 5}, "left"},
+		{structs.Position{X: 9, Y: 5}, structs.Position{X: 0, Y: 5}, "right"},
+		{structs.Position{X: 5, Y: 0}, structs.Position{X: 5, Y: 9}, "up"},
+		{structs.Position{X: 5, Y: 9}, structs.Position{X: 5, Y: 0}, "down"},
+		// 不相邻
+		{structs.Position{X: 5, Y: 5}, structs.Position{X: 6, Y: 6}, ""},
+		{structs.Position{X: 5, Y: 5}, structs.Position{X: 5, Y: 5}, ""},
+	}
+	for _, tt := range tests {
+		if got := neighbourDirection(tt.a, tt.b); got != tt.want {
+			t.Errorf("neighbourDirection(%v, %v) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+	for i, d := range []string{"up", "right", "down", "left", ""} {
+		if got := turnsFor(d); got != i%4 {
+			t.Errorf("turnsFor(%q) = %d, want %d", d, got, i%4)
+		}
+	}
+}
+
+func TestSnakeSprites(t *testing.T) {
+	head := func(turns int) [2]int { return [2]int{spriteHead, turns} }
+	body := func(turns int) [2]int { return [2]int{spriteBody, turns} }
+	corner := func(turns int) [2]int { return [2]int{spriteCorner, turns} }
+	tail := func(turns int) [2]int { return [2]int{spriteTail, turns} }
+
+	tests := []struct {
+		name      string
+		direction string
+		cells     [][2]int
+		want      [][2]int
+	}{
+		{"single head", "right", [][2]int{{5, 5}}, [][2]int{head(1)}},
+		{"head and tail facing down", "down", [][2]int{{5, 6}, {5, 5}}, [][2]int{head(2), tail(2)}},
+		{"straight vertical", "up", [][2]int{{5, 4}, {5, 5}, {5, 6}}, [][2]int{head(0), body(0), tail(0)}},
+		{"straight horizontal", "left", [][2]int{{4, 5}, {5, 5}, {6, 5}}, [][2]int{head(3), body(1), tail(3)}},
+		// 拐角的部件连接右方和下方，每次顺时针旋转
+		{"corner right and down", "right", [][2]int{{6, 5}, {5, 5}, {5, 6}}, [][2]int{head(1), corner(0), tail(0)}},
+		{"corner down and left", "down", [][2]int{{5, 6}, {5, 5}, {4, 5}}, [][2]int{head(2), corner(1), tail(1)}},
+		{"corner left and up", "left", [][2]int{{4, 5}, {5, 5}, {5, 4}}, [][2]int{head(3), corner(2), tail(2)}},
+		{"corner up and right", "up", [][2]int{{5, 4}, {5, 5}, {6, 5}}, [][2]int{head(0), corner(3), tail(3)}},
+		// 蛇头跨过左边缘，拐角和尾巴仍然连接前一节
+		{"across the edge", "left", [][2]int{{9, 5}, {0, 5}, {0, 4}}, [][2]int{head(3), corner(2), tail(2)}},
+		// 不相邻的几节按蛇头的方向画
+		{"gap", "right", [][2]int{{1, 1}, {5, 5}, {8, 8}}, [][2]int{head(1), body(1), tail(1)}},
+	}
+	th := theme.Default()
+	for _, tt := range tests {
+		s := structs.Snake{OpenID: "alice", Direction: tt.direction}
+		for _, c := range tt.cells {
+			s.Positions = append(s.Positions, structs.Position{X: c[0], Y: c[1]})
+		}
+		placements := snakeSprites(s, 20, tt.direction, th)
+		if len(placements) != len(tt.want) {
+			t.Fatalf("%s: %d placements, want %d", tt.name, len(placements), len(tt.want))
+		}
+		for i, p := range placements {
+			if p.Pos != s.Positions[i] {
+				t.Errorf("%s: placement %d at %v, want %v", tt.name, i, p.Pos, s.Positions[i])
+			}
+			if got := placedPart(t, p); got != tt.want[i] {
+				t.Errorf("%s: segment %d is part %d turned %d, want part %d turned %d", tt.name, i, got[0], got[1], tt.want[i][0], tt.want[i][1])
+			}
+		}
+	}
+}
+
+func TestSpriteSheetRotation(t *testing.T) {
+	sheet := &spriteSheet{cache: make(map[string]image.Image)}
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	const size = 20
+	alpha := func(img image.Image, x, y int) uint32 {
+		_, _, _, a := img.At(img.Bounds().Min.X+x, img.Bounds().Min.Y+y).RGBA()
+		return a
+	}
+	// 尾巴的宽边在上方，顺时针旋转后依次在右方、下方和左方
+	wide := [][2]int{{size / 2, 0}, {size - 1, size / 2}, {size / 2, size - 1}, {0, size / 2}}
+	for turns, p := range wide {
+		img := sheet.get(spriteTail, white, turns, size)
+		if img.Bounds().Dx() != size || img.Bounds().Dy() != size {
+			t.Fatalf("turns %d: sprite is %v", turns, img.Bounds())
+		}
+		if alpha(img, p[0], p[1]) == 0 {
+			t.Errorf("turns %d: wide end is not at %v", turns, p)
+		}
+		opposite := wide[(turns+2)%4]
+		if alpha(img, opposite[0], opposite[1]) != 0 {
+			t.Errorf("turns %d: tip at %v is not empty", turns, opposite)
+		}
+	}
+
+	// 着色按颜色相乘
+	red := color.NRGBA{R: 200, A: 255}
+	img := sheet.get(spriteBody, red, 0, size)
+	if r, g, b, a := img.At(size/2, 1).RGBA(); r>>8 != 200 || g != 0 || b != 0 || a>>8 != 255 {
+		t.Errorf("tinted body is %v", img.At(size/2, 1))
+	}
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hoshinonyaruko/snake-in-im/repository"
+	"github.com/hoshinonyaruko/snake-in-im/snake"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// StateHandler 以JSON返回群组游戏的完整状态，只读
+// 返回的是按当前的输入执行到期的刷新之后的预览，不会保存，也不会加入新玩家；
+// 这些刷新真正执行之前如果有玩家加入或修改方向，保存的结果会与预览不同
+func StateHandler(repo repository.GameRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		groupID := c.Query("groupid")
+
+		if groupID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "groupID is required"})
+			return
+		}
+
+		// 同一群组的请求依次执行
+		unlock := groupLocks.Lock(groupID)
+		defer unlock()
+
+		game, err := repo.Load(groupID)
+		if err == repository.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Game map not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to load game map for groupID %s: %v", groupID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to fetch game map"})
+			return
+		}
+
+		// 在载入的副本上计算到期的刷新，不保存
+		result, err := snake.DefaultEngine.Advance(game)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update game map"})
+			return
+		}
+
+		deaths, err := repo.Deaths(groupID, 0)
+		if err != nil {
+			log.Printf("Failed to load deaths for groupID %s: %v", groupID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to load deaths"})
+			return
+		}
+
+		// 击杀数和排名同样包含预览中的淘汰
+		state := buildGameState(game, append(deaths, result.Deaths...))
+		state.Deaths = append(state.Deaths, result.Deaths...)
+		c.JSON(http.StatusOK, state)
+	}
+}
+
+// buildGameState 把游戏转换为状态接口的结构，deaths为该群组的全部淘汰记录，用于统计击杀数
+func buildGameState(game *structs.Game, deaths []structs.Death) structs.GameState {
+	kills := make(map[string]int)
+	for _, death := range deaths {
+		if death.KillerID != "" {
+			kills[death.KillerID]++
+		}
+	}
+
+	state := structs.GameState{
+		SchemaVersion:   structs.StateSchemaVersion,
+		GroupID:         game.GroupID,
+		Width:           game.Map.Width,
+		Height:          game.Map.Height,
+		Tick:            game.Tick,
+		LastRefresh:     game.LastRefresh,
+		RefreshInterval: game.RefreshInterval,
+		NextTickAt:      game.LastRefresh + int64(game.RefreshInterval),
+		Background:      game.Background,
+		Renderer:        game.Renderer,
+		Theme:           game.Theme,
+		Edges:           game.Map.Edges,
+		Level:           game.Level,
+		Tiles:           append([]structs.Tile{}, game.Map.Tiles...),
+		Snakes:          []structs.SnakeState{},
+		Food:            append([]structs.Position{}, game.Map.Food...),
+		Deaths:          []structs.Death{},
+	}
+
+	for _, id := range snake.SortedSnakeIDs(game.Map.Snakes) {
+		s := game.Map.Snakes[id]
+		snakeState := structs.SnakeState{
+			OpenID:    s.OpenID,
+			Nickname:  game.Nicknames[s.OpenID],
+			Direction: s.Direction,
+			Length:    len(s.Positions),
+			Kills:     kills[s.OpenID],
+			Score:     s.Score,
+			SpeedUp:   s.SpeedUp,
+			Shield:    s.Shield,
+			Poison:    s.Poison,
+			Positions: s.Positions,
+		}
+		if len(s.Positions) > 0 {
+			snakeState.Head = s.Positions[0]
+		}
+		state.Snakes = append(state.Snakes, snakeState)
+	}
+
+	// 按长度、击杀数、得分排名，相同时按OpenID排列
+	sort.SliceStable(state.Snakes, func(i, j int) bool {
+		a, b := state.Snakes[i], state.Snakes[j]
+		if a.Length != b.Length {
+			return a.Length > b.Length
+		}
+		if a.Kills != b.Kills {
+			return a.Kills > b.Kills
+		}
+		return a.Score > b.Score
+	})
+	for i := range state.Snakes {
+		state.Snakes[i].Rank = i + 1
+	}
+
+	return state
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hoshinonyaruko/snake-in-im/config"
+)
+
+// 渲染结果保存在 ./static/renders/<群组>/ 下，文件名包含刷新次数、观看者和内容哈希
+const rendersDir = "./static/renders"
+
+// 渲染结果的存放方式
+const (
+	renderStoreDisk   = "disk"   // 写入static目录，由静态文件服务提供
+	renderStoreMemory = "memory" // 只保存在内存中，由/renders提供
+	renderStoreBoth   = "both"   // 内存提供访问，同时写入磁盘留档
+)
+
+// safeName 把群组ID、用户ID转换为安全的文件名
+// 字母、数字和-保持不变，其他字节（包括_本身）写成_加两位十六进制，不同的ID总是得到不同的名称
+func safeName(id string) string {
+	var name strings.Builder
+	for i := 0; i < len(id); i++ {
+		b := id[i]
+		if b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '-' {
+			name.WriteByte(b)
+		} else {
+			fmt.Fprintf(&name, "_%02x", b)
+		}
+	}
+	return name.String()
+}
+
+// renderStoreMode 返回配置的存放方式，未知的值按disk处理
+func renderStoreMode() string {
+	switch mode := config.GetConfigValue("renderstore").(string); mode {
+	case renderStoreMemory, renderStoreBoth:
+		return mode
+	default:
+		return renderStoreDisk
+	}
+}
+
+// storeRender 保存一次渲染并返回访问路径（以/开头）
+// 文件名由刷新次数、观看者和内容哈希组成，同一内容总是得到同一路径，不同内容不会互相覆盖
+// 扩展名由contentType决定
+func storeRender(groupID string, tick int64, viewer string, data []byte, contentType string) (string, error) {
+	sum := sha256.Sum256(data)
+	name := fmt.Sprintf("%d-%s-%s%s", tick, safeName(viewer), hex.EncodeToString(sum[:8]), extensionFor(contentType))
+	group := safeName(groupID)
+
+	mode := renderStoreMode()
+	if mode != renderStoreMemory {
+		if err := writeRenderFile(group, name, data); err != nil {
+			return "", err
+		}
+	}
+	if mode == renderStoreDisk {
+		return path.Join("/static/renders", group, name), nil
+	}
+
+	renders.Put(group+"/"+name, contentType, data)
+	return path.Join("/renders", group, name), nil
+}
+
+// writeRenderFile 先写临时文件再重命名，读者不会看到写了一半的文件
+func writeRenderFile(group, name string, data []byte) error {
+	dir := filepath.Join(rendersDir, group)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	os.Chmod(tmp.Name(), 0644)
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// minRenderRetention 清理时至少保留的渲染结果数量，一次请求最多写入图片和GIF两个文件
+const minRenderRetention = 2
+
+// pruneRenders 只保留群组在磁盘上最近的keep个渲染结果，keep小于1时不清理
+// current为本次请求刚写入的文件的访问路径，总是保留并计入keep
+// 内存中的渲染结果由LRU淘汰，不需要清理
+func pruneRenders(groupID string, keep int, current ...string) error {
+	if keep < 1 || renderStoreMode() == renderStoreMemory {
+		return nil
+	}
+	if keep < minRenderRetention {
+		keep = minRenderRetention
+	}
+	dir := filepath.Join(rendersDir, safeName(groupID))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	protected := make(map[string]bool, len(current))
+	for _, urlPath := range current {
+		if urlPath != "" {
+			protected[path.Base(urlPath)] = true
+		}
+	}
+
+	type render struct {
+		name    string
+		modTime int64
+	}
+	var files []render
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".tmp-") || protected[entry.Name()] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, render{entry.Name(), info.ModTime().UnixNano()})
+	}
+	keep -= len(protected)
+	if keep < 0 {
+		keep = 0
+	}
+	if len(files) <= keep {
+		return nil
+	}
+
+	// 从新到旧排列，删除超出数量的旧文件
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime > files[j].modTime })
+	for _, r := range files[keep:] {
+		if err := os.Remove(filepath.Join(dir, r.name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeRenders 删除群组的全部渲染结果
+func removeRenders(groupID string) error {
+	renders.RemovePrefix(safeName(groupID) + "/")
+	return os.RemoveAll(filepath.Join(rendersDir, safeName(groupID)))
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSafeName(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"123456", "123456"},
+		{"group-1", "group-1"},
+		{"a_b", "a_5fb"},
+		{"a/b", "a_2fb"},
+		{"a.b", "a_2eb"},
+		{"../x", "_2e_2e_2fx"},
+		{"群", "_e7_be_a4"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := safeName(tt.id); got != tt.want {
+			t.Errorf("safeName(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+
+	// 只有分隔符不同的ID不能共用目录
+	seen := make(map[string]string)
+	for _, id := range []string{"a b", "a_b", "a-b", "a.b", "a/b", "a:b", "a_20b", "a_5fb", "ab"} {
+		name := safeName(id)
+		if other, ok := seen[name]; ok {
+			t.Errorf("%q and %q both map to %q", id, other, name)
+		}
+		seen[name] = id
+	}
+}
+
+// writeRenders 在群组目录中写入按顺序变新的文件
+func writeRenders(t *testing.T, groupID string, names ...string) string {
+	t.Helper()
+	dir := filepath.Join(rendersDir, safeName(groupID))
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	base := time.Now().Add(-time.Hour)
+	for i, name := range names {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func remainingRenders(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestPruneRenders(t *testing.T) {
+	tests := []struct {
+		name    string
+		keep    int
+		current []string
+		want    []string
+	}{
+		{"keeps the newest", 3, nil, []string{"3.png", "4.png", "5.png"}},
+		{"zero disables pruning", 0, nil, []string{"1.png", "2.gif", "3.png", "4.png", "5.png"}},
+		{"at least image and gif", 1, nil, []string{"4.png", "5.png"}},
+		// 时钟回拨等原因导致本次写入的文件不是最新的，也不能被删除
+		{"keeps the current files", 2, []string{"/static/renders/g/1.png", "/static/renders/g/2.gif"}, []string{"1.png", "2.gif"}},
+		{"current files count towards keep", 3, []string{"/static/renders/g/1.png"}, []string{"1.png", "4.png", "5.png"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groupID := "prune " + tt.name
+			dir := writeRenders(t, groupID, "1.png", "2.gif", "3.png", "4.png", "5.png")
+			defer os.RemoveAll(dir)
+			var current []string
+			for _, c := range tt.current {
+				current = append(current, path.Join("/static/renders", safeName(groupID), path.Base(c)))
+			}
+			if err := pruneRenders(groupID, tt.keep, current...); err != nil {
+				t.Fatal(err)
+			}
+			got := remainingRenders(t, dir)
+			if len(got) != len(tt.want) {
+				t.Fatalf("remaining %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("remaining %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,452 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/hoshinonyaruko/snake-in-im/config"
+	"github.com/hoshinonyaruko/snake-in-im/memimg"
+	"github.com/hoshinonyaruko/snake-in-im/snake"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+	"github.com/hoshinonyaruko/snake-in-im/theme"
+)
+
+const rendererSVG = "svg" // 矢量图
+
+func init() {
+	RegisterRenderer(rendererSVG, RendererFunc(renderSVG))
+}
+
+// svgCanvas 拼接SVG元素，同一张图片只嵌入一次，之后通过<use>引用
+type svgCanvas struct {
+	defs   strings.Builder
+	body   strings.Builder
+	images map[string]string // 图片名到defs中id的映射
+	clips  int               // 已经定义的裁剪路径数量
+	theme  *theme.Theme      // 颜色与位图使用同一个主题
+}
+
+func newSVGCanvas(th *theme.Theme) *svgCanvas {
+	return &svgCanvas{images: make(map[string]string), theme: th}
+}
+
+// svgColor 把颜色写作#rrggbb，半透明时写作#rrggbbaa
+func svgColor(c color.NRGBA) string {
+	if c.A == 255 {
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+func (s *svgCanvas) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&s.body, format, args...)
+}
+
+// embed 把图片编码为data URI放入defs，返回引用的id
+// PNG图片保留透明度，其余图片使用JPEG以减小体积
+func (s *svgCanvas) embed(name string, img image.Image, size int) string {
+	if id, ok := s.images[name]; ok {
+		return id
+	}
+	if img.Bounds().Dx() != size || img.Bounds().Dy() != size {
+		img = imaging.Resize(img, size, size, imaging.Lanczos)
+	}
+	var buf bytes.Buffer
+	mimeType := "image/jpeg"
+	if strings.HasSuffix(name, ".png") {
+		mimeType = "image/png"
+		png.Encode(&buf, img)
+	} else {
+		jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	}
+	id := fmt.Sprintf("img%d", len(s.images))
+	s.images[name] = id
+	fmt.Fprintf(&s.defs, `<image id="%s" width="%d" height="%d" href="data:%s;base64,%s"/>`+"\n",
+		id, size, size, mimeType, base64.StdEncoding.EncodeToString(buf.Bytes()))
+	return id
+}
+
+// block 在格子上放一张图片，图片缺失时使用主题的缺失颜色画出方块，与位图一致
+func (s *svgCanvas) block(pos structs.Position, img image.Image, found bool, blockSize int) {
+	if !found {
+		s.printf(`<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`+"\n", pos.X*blockSize, pos.Y*blockSize, blockSize, blockSize, svgColor(s.theme.Missing))
+		return
+	}
+	s.printf(`<use href="#%s" x="%d" y="%d"/>`+"\n", s.embed(pos.Avatar, img, blockSize), pos.X*blockSize, pos.Y*blockSize)
+}
+
+// background 绘制群组背景，纯色使用矩形，图片缩小到画面大小后嵌入，主题自带背景时替换群组的背景
+func (s *svgCanvas) background(spec string, width, height int) {
+	s.printf(`<rect width="%d" height="%d" fill="#fff"/>`+"\n", width, height)
+	if th := s.theme; th.HasBackground() {
+		if th.BackgroundColor != nil {
+			s.printf(`<rect width="%d" height="%d" fill="%s"/>`+"\n", width, height, svgColor(*th.BackgroundColor))
+		}
+		if th.BackgroundImage != nil {
+			s.coverImage(th.BackgroundImage, width, height)
+		}
+		return
+	}
+	kind, value, err := parseBackground(spec)
+	if err != nil {
+		return
+	}
+	var bgImg image.Image
+	var found bool
+	switch kind {
+	case backgroundColor:
+		s.printf(`<rect width="%d" height="%d" fill="%s"/>`+"\n", width, height, value)
+		return
+	case backgroundAvatar:
+		bgImg, found = memimg.GetAvatarFromMemory(fmt.Sprintf("%s_blur.jpg", value))
+	case backgroundImage:
+		bgImg, found = memimg.GetBackgroundFromMemory(value)
+	}
+	if !found {
+		return
+	}
+	s.coverImage(bgImg, width, height)
+}
+
+// coverImage 与位图一样铺满并居中
+func (s *svgCanvas) coverImage(bgImg image.Image, width, height int) {
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, imaging.Fill(bgImg, width, height, imaging.Center, imaging.Lanczos), &jpeg.Options{Quality: 80})
+	s.printf(`<image width="%d" height="%d" href="data:image/jpeg;base64,%s"/>`+"\n", width, height, base64.StdEncoding.EncodeToString(buf.Bytes()))
+}
+
+// grid 用一条路径画出全部网格线，样式与renderGrid一致
+func (s *svgCanvas) grid(width, height, blockSize int) {
+	grid := s.theme.Grid
+	var d strings.Builder
+	switch grid.Style {
+	case theme.GridNone:
+		return
+	case theme.GridDotted:
+		for x := 0; x <= width; x += blockSize {
+			for y := 0; y <= height; y += blockSize {
+				fmt.Fprintf(&d, "M%g %ga%g %g 0 1 0 %g 0a%g %g 0 1 0 %g 0",
+					float64(x)-grid.Width, float64(y), grid.Width, grid.Width, grid.Width*2, grid.Width, grid.Width, -grid.Width*2)
+			}
+		}
+		s.printf(`<path d="%s" fill="%s"/>`+"\n", d.String(), svgColor(grid.Color))
+		return
+	}
+	for x := 0; x <= width; x += blockSize {
+		fmt.Fprintf(&d, "M%d 0V%d", x, height)
+	}
+	for y := 0; y <= height; y += blockSize {
+		fmt.Fprintf(&d, "M0 %dH%d", y, width)
+	}
+	dash := ""
+	if grid.Style == theme.GridDashed {
+		dash = fmt.Sprintf(` stroke-dasharray="%g"`, float64(blockSize)/4)
+	}
+	s.printf(`<path d="%s" stroke="%s" stroke-width="%g"%s fill="none"/>`+"\n", d.String(), svgColor(grid.Color), grid.Width, dash)
+}
+
+// walls 地图边缘的墙，与drawWalls一致
+func (s *svgCanvas) walls(gameMap *structs.GameMap, w viewWindow, blockSize int) {
+	xs, ys := edgeLines(gameMap, w)
+	var d strings.Builder
+	for _, x := range xs {
+		fmt.Fprintf(&d, "M%d 0V%d", x*blockSize, w.Rows*blockSize)
+	}
+	for _, y := range ys {
+		fmt.Fprintf(&d, "M0 %dH%d", y*blockSize, w.Cols*blockSize)
+	}
+	if d.Len() > 0 {
+		s.printf(`<path d="%s" stroke="%s" stroke-width="%g" fill="none"/>`+"\n", d.String(), svgColor(s.theme.Wall), wallWidth(blockSize))
+	}
+}
+
+// tiles 关卡中的墙和障碍物，与drawTiles一致
+func (s *svgCanvas) tiles(tiles []structs.Tile, blockSize int) {
+	var walls strings.Builder
+	for _, tile := range tiles {
+		switch tile.Kind {
+		case structs.TileWall:
+			fmt.Fprintf(&walls, "M%d %dh%dv%dh-%dz", tile.X*blockSize, tile.Y*blockSize, blockSize, blockSize, blockSize)
+		case structs.TileObstacle:
+			bs := float64(blockSize)
+			inset := bs / 10
+			s.printf(`<rect x="%g" y="%g" width="%g" height="%g" rx="%g" fill="%s"/>`+"\n",
+				float64(tile.X)*bs+inset, float64(tile.Y)*bs+inset, bs-2*inset, bs-2*inset, bs/4, svgColor(s.theme.Obstacle))
+		}
+	}
+	if walls.Len() > 0 {
+		s.printf(`<path d="%s" fill="%s"/>`+"\n", walls.String(), svgColor(s.theme.Wall))
+	}
+}
+
+// arrow 与drawDirectionArrow使用相同的线条
+func (s *svgCanvas) arrow(pos structs.Position, blockSize int, direction string) {
+	x, y, b := float64(pos.X*blockSize), float64(pos.Y*blockSize), float64(blockSize)
+	half := float64(blockSize / 2)
+	length := float64(int(b * 0.7))
+	var lines [2][4]float64
+	switch direction {
+	case "up":
+		lines = [2][4]float64{{x + half, y - half, x - half, y + length}, {x + b - half, y - half, x + b + half, y + length}}
+	case "down":
+		lines = [2][4]float64{{x + half, y + b + half, x - half, y - length + b}, {x + b - half, y + b + half, x + b + half, y - length + b}}
+	case "left":
+		lines = [2][4]float64{{x - half, y + half, x + length, y - half}, {x - half, y + b - half, x + length, y + b + half}}
+	case "right":
+		lines = [2][4]float64{{x + b + half, y + half, x - length + b, y - half}, {x + b + half, y + b - half, x - length + b, y + b + half}}
+	default:
+		return
+	}
+	s.printf(`<path d="M%g %gL%g %gM%g %gL%g %g" stroke="%s" stroke-width="1" fill="none"/>`+"\n",
+		lines[0][0], lines[0][1], lines[0][2], lines[0][3], lines[1][0], lines[1][1], lines[1][2], lines[1][3], svgColor(s.theme.Arrow))
+}
+
+// snake 按选择的画法画出一条蛇和蛇头的方向
+func (s *svgCanvas) snake(sn structs.Snake, blockSize int, view renderView) {
+	direction := view.arrowDirection(sn)
+	if view.Style == snakeStyleSprites {
+		s.spriteSnake(sn, blockSize, direction, view.AvatarHead)
+		return
+	}
+	for i, pos := range sn.Positions {
+		img, found := memimg.GetAvatarFromMemory(pos.Avatar)
+		if !found {
+			img, found = memimg.GetFoodFromMemory(pos.Avatar)
+		}
+		s.block(pos, img, found, blockSize)
+		if i == 0 {
+			s.arrow(pos, blockSize, direction)
+		}
+	}
+}
+
+// spriteSnake 与drawSpriteSnake一致，从尾部画到头部，头像裁剪为圆形画在蛇头上
+func (s *svgCanvas) spriteSnake(sn structs.Snake, blockSize int, direction string, avatarHead bool) {
+	placements := snakeSprites(sn, blockSize, direction, s.theme)
+	for i := len(placements) - 1; i >= 0; i-- {
+		p := placements[i]
+		s.printf(`<use href="#%s" x="%d" y="%d"/>`+"\n", s.embed(p.Key+".png", p.Img, blockSize), p.Pos.X*blockSize, p.Pos.Y*blockSize)
+	}
+	if !avatarHead || len(sn.Positions) == 0 {
+		return
+	}
+	head := sn.Positions[0]
+	avatar, found := memimg.GetAvatarFromMemory(head.Avatar)
+	if !found {
+		return
+	}
+	b := float64(blockSize)
+	r := b * 3 / 10
+	cx, cy := float64(head.X)*b+b/2, float64(head.Y)*b+b/2
+	clipID := fmt.Sprintf("clip%d", s.clips)
+	s.clips++
+	fmt.Fprintf(&s.defs, `<clipPath id="%s"><circle cx="%g" cy="%g" r="%g"/></clipPath>`+"\n", clipID, cx, cy, r)
+	// 裁剪放在外层的<g>上，圆形使用地图坐标而不是缩放后的坐标
+	s.printf(`<g clip-path="url(#%s)"><use href="#%s" transform="translate(%g %g) scale(%g)"/></g>`+"\n",
+		clipID, s.embed(head.Avatar, avatar, blockSize), cx-r, cy-r, r*2/b)
+}
+
+// highlight 观看者的光晕、描边和"你"标记，与位图一致
+func (s *svgCanvas) highlight(sn structs.Snake, blockSize int, glow bool) {
+	if len(sn.Positions) == 0 {
+		return
+	}
+	b := float64(blockSize)
+	highlight := svgColor(s.theme.Highlight)
+	if glow {
+		spread := b / 5
+		for _, pos := range sn.Positions {
+			s.printf(`<rect x="%g" y="%g" width="%g" height="%g" rx="%g" fill="%s" fill-opacity="0.45"/>`+"\n",
+				float64(pos.X)*b-spread, float64(pos.Y)*b-spread, b+spread*2, b+spread*2, spread, highlight)
+		}
+		return
+	}
+	for _, pos := range sn.Positions {
+		s.printf(`<rect x="%g" y="%g" width="%g" height="%g" stroke="%s" stroke-width="2" fill="none"/>`+"\n",
+			float64(pos.X)*b+1, float64(pos.Y)*b+1, b-2, b-2, highlight)
+	}
+	head := sn.Positions[0]
+	x := float64(head.X)*b + b/2
+	y := float64(head.Y)*b - 9
+	if head.Y == 0 {
+		y = float64(head.Y+1)*b + 9
+	}
+	s.printf(`<rect x="%g" y="%g" width="18" height="16" rx="3" fill="%s"/>`+"\n", x-9, y-8, highlight)
+	s.printf(`<text x="%g" y="%g" font-size="12" text-anchor="middle" dominant-baseline="central" fill="#000">%s</text>`+"\n", x, y, youLabel)
+}
+
+// miniMap 右下角的缩略图，与drawMiniMap一致
+func (s *svgCanvas) miniMap(gameMap *structs.GameMap, w viewWindow, viewer string, width, height, size int) {
+	longest := gameMap.Width
+	if gameMap.Height > longest {
+		longest = gameMap.Height
+	}
+	if size > width/2 {
+		size = width / 2
+	}
+	if size > height/2 {
+		size = height / 2
+	}
+	if longest <= 0 || size <= 0 {
+		return
+	}
+	cell := float64(size) / float64(longest)
+	mapWidth, mapHeight := cell*float64(gameMap.Width), cell*float64(gameMap.Height)
+	left, top := float64(width)-mapWidth-4, float64(height)-mapHeight-4
+
+	s.printf(`<svg x="%g" y="%g" width="%g" height="%g" viewBox="0 0 %d %d" preserveAspectRatio="none">`+"\n",
+		left, top, mapWidth, mapHeight, gameMap.Width, gameMap.Height)
+	s.printf(`<rect width="%d" height="%d" fill="#000" fill-opacity="0.6"/>`+"\n", gameMap.Width, gameMap.Height)
+	dots := func(positions []structs.Position, fill string) {
+		var d strings.Builder
+		for _, pos := range positions {
+			fmt.Fprintf(&d, "M%d %dh1v1h-1z", pos.X, pos.Y)
+		}
+		if d.Len() > 0 {
+			s.printf(`<path d="%s" fill="%s"/>`+"\n", d.String(), fill)
+		}
+	}
+	var blocked []structs.Position
+	for _, tile := range gameMap.Tiles {
+		if tile.Kind == structs.TileWall || tile.Kind == structs.TileObstacle {
+			blocked = append(blocked, structs.Position{X: tile.X, Y: tile.Y})
+		}
+	}
+	dots(blocked, "#808080")
+	dots(gameMap.Food, "#66e666")
+	for _, id := range snake.SortedSnakeIDs(gameMap.Snakes) {
+		if id != viewer {
+			dots(gameMap.Snakes[id].Positions, "#fff")
+		}
+	}
+	dots(gameMap.Snakes[viewer].Positions, svgColor(s.theme.Highlight))
+	for i := -1; i <= 0; i++ {
+		for j := -1; j <= 0; j++ {
+			s.printf(`<rect x="%d" y="%d" width="%d" height="%d" stroke="#ff3333" stroke-width="%g" fill="none"/>`+"\n",
+				w.X+i*gameMap.Width, w.Y+j*gameMap.Height, w.Cols, w.Rows, 1/cell)
+		}
+	}
+	s.printf("</svg>\n")
+}
+
+// scoreboard 排行榜，布局与addScoreboard一致，返回地图在画面中的偏移和整个画面的大小
+func (s *svgCanvas) scoreboard(ranking []structs.SnakeState, layout string, boardWidth, boardHeight, blockSize int) (int, int, int, int) {
+	if layout != scoreboardSide && layout != scoreboardTop {
+		return 0, 0, boardWidth, boardHeight
+	}
+	lineHeight := blockSize
+	if lineHeight < scoreboardMinLine {
+		lineHeight = scoreboardMinLine
+	}
+	rows := len(ranking)
+	if layout == scoreboardTop && rows > scoreboardTopRows {
+		rows = scoreboardTopRows
+	}
+	// 文字宽度按每个字符一格估算，矢量图缩放后仍然清晰
+	textWidth := 0
+	for _, st := range ranking[:rows] {
+		if w := len([]rune(displayName(st)))*12 + 170; w > textWidth {
+			textWidth = w
+		}
+	}
+	panelWidth := scoreboardPadding*2 + lineHeight + 2 + textWidth
+	panelHeight := scoreboardPadding*2 + (rows+1)*lineHeight
+
+	var panelX, panelY, boardX, boardY, width, height int
+	if layout == scoreboardSide {
+		if panelHeight < boardHeight {
+			panelHeight = boardHeight
+		}
+		panelX = boardWidth
+		width, height = boardWidth+panelWidth, panelHeight
+	} else {
+		if panelWidth < boardWidth {
+			panelWidth = boardWidth
+		}
+		boardY = panelHeight
+		width, height = panelWidth, panelHeight+boardHeight
+	}
+
+	s.printf(`<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`+"\n", panelX, panelY, panelWidth, panelHeight, svgColor(s.theme.Scoreboard.Background))
+	x := panelX + scoreboardPadding
+	y := panelY + scoreboardPadding
+	s.printf(`<text x="%d" y="%d" font-size="12" dominant-baseline="central" fill="%s">排行榜</text>`+"\n", x, y+lineHeight/2, svgColor(s.theme.Scoreboard.Title))
+	iconSize := lineHeight - 4
+	for i, st := range ranking[:rows] {
+		rowY := y + (i+1)*lineHeight
+		img, found := memimg.GetAvatarFromMemory(st.Head.Avatar)
+		if found {
+			s.printf(`<use href="#%s" transform="translate(%d %d) scale(%g)"/>`+"\n",
+				s.embed(st.Head.Avatar, img, blockSize), x, rowY+2, float64(iconSize)/float64(blockSize))
+		} else {
+			s.printf(`<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`+"\n", x, rowY+2, iconSize, iconSize, svgColor(s.theme.Missing))
+		}
+		s.printf(`<text x="%d" y="%d" font-size="12" dominant-baseline="central" fill="%s">%s</text>`+"\n",
+			x+iconSize+6, rowY+lineHeight/2, svgColor(s.theme.Scoreboard.Text), html.EscapeString(fmt.Sprintf("%d. %s  长度%d  击杀%d  得分%d", st.Rank, displayName(st), st.Length, st.Kills, st.Score)))
+	}
+	return boardX, boardY, width, height
+}
+
+// renderSVG 把地图渲染为SVG，网格、蛇、食物和标记都是矢量元素，头像和食物图片嵌入在文件中
+// 与位图使用相同的视口、绘制顺序和方向箭头
+func renderSVG(game *structs.Game, viewer string, opts RenderOptions) ([]byte, string, error) {
+	blockSize := config.GetConfigValue("blocksize").(int)
+	view := opts.view(viewer)
+	window := view.Viewport.window(&game.Map, viewer)
+	local := window.apply(&game.Map)
+	boardWidth, boardHeight := local.Width*blockSize, local.Height*blockSize
+
+	s := newSVGCanvas(view.Theme)
+	boardX, boardY, width, height := s.scoreboard(opts.Ranking, opts.Scoreboard, boardWidth, boardHeight, blockSize)
+
+	// 地图放在独立的<svg>中，超出地图的箭头和光晕被裁掉
+	s.printf(`<svg x="%d" y="%d" width="%d" height="%d">`+"\n", boardX, boardY, boardWidth, boardHeight)
+	s.background(game.Background, boardWidth, boardHeight)
+	s.grid(boardWidth, boardHeight, blockSize)
+	s.walls(&game.Map, window, blockSize)
+	s.tiles(local.Tiles, blockSize)
+	for _, food := range local.Food {
+		img, found := memimg.GetFoodFromMemory(food.Avatar)
+		s.block(food, img, found, blockSize)
+	}
+	viewerSnake, viewerAlive := local.Snakes[viewer]
+	for _, id := range snake.SortedSnakeIDs(local.Snakes) {
+		if viewerAlive && id == viewer {
+			continue
+		}
+		s.snake(local.Snakes[id], blockSize, view)
+	}
+	if viewerAlive {
+		if view.Highlight {
+			s.highlight(viewerSnake, blockSize, true)
+		}
+		s.snake(viewerSnake, blockSize, view)
+		if view.Highlight {
+			s.highlight(viewerSnake, blockSize, false)
+		}
+	}
+	if window.cropped(&game.Map) && view.Viewport.MiniMap {
+		s.miniMap(&game.Map, window, viewer, boardWidth, boardHeight, config.GetConfigValue("minimapsize").(int))
+	}
+	s.printf("</svg>\n")
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif">`+"\n",
+		width, height, width, height)
+	if s.defs.Len() > 0 {
+		out.WriteString("<defs>\n")
+		out.WriteString(s.defs.String())
+		out.WriteString("</defs>\n")
+	}
+	out.WriteString(s.body.String())
+	out.WriteString("</svg>\n")
+	return out.Bytes(), "image/svg+xml", nil
+}
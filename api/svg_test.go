@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/xml"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/hoshinonyaruko/snake-in-im/memimg"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// svgAvatar 文件名中带有引号和尖括号的头像，名称不应出现在SVG中
+const svgAvatar = `a"><script>x</script>.png`
+
+// useAvatar 在内存中放入一张头像，测试结束后移除
+func useAvatar(t *testing.T, name string) {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	memimg.AvatarsMutex.Lock()
+	if memimg.Avatars == nil {
+		memimg.Avatars = make(map[string]image.Image)
+	}
+	memimg.Avatars[name] = img
+	memimg.AvatarsMutex.Unlock()
+	t.Cleanup(func() {
+		memimg.AvatarsMutex.Lock()
+		delete(memimg.Avatars, name)
+		memimg.AvatarsMutex.Unlock()
+	})
+}
+
+// svgGame 昵称中带有XML特殊字符的两条蛇，viewer的身体跨过地图的左边缘
+func svgGame() *structs.Game {
+	return &structs.Game{
+		Background: "color:#203040",
+		Nicknames:  map[string]string{"viewer": `<i>"A&B"`, "other": `O'Neil`},
+		Map: structs.GameMap{
+			Width:  20,
+			Height: 20,
+			Edges:  "wrap",
+			Snakes: map[string]structs.Snake{
+				"viewer": {OpenID: "viewer", Direction: "right", Positions: []structs.Position{{X: 0, Y: 0, Avatar: svgAvatar}, {X: 19, Y: 0, Avatar: svgAvatar}}},
+				"other":  {OpenID: "other", Direction: "up", Positions: []structs.Position{{X: 5, Y: 5, Avatar: "missing.png"}}},
+			},
+			Food:  []structs.Position{{X: 3, Y: 3, Avatar: "food_small.png"}},
+			Tiles: []structs.Tile{{X: 1, Y: 1, Kind: structs.TileWall}, {X: 2, Y: 2, Kind: structs.TileObstacle}},
+		},
+	}
+}
+
+// svgText 检查SVG是格式正确的XML，返回其中全部文字
+func svgText(t *testing.T, data []byte) string {
+	t.Helper()
+	decoder := xml.NewDecoder(strings.NewReader(string(data)))
+	var text strings.Builder
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("malformed SVG: %v\n%s", err, data)
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			if depth == 0 && token.Name.Local != "svg" {
+				t.Fatalf("root element is <%s>", token.Name.Local)
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			text.Write(token)
+		}
+	}
+	if depth != 0 {
+		t.Fatalf("%d unclosed elements", depth)
+	}
+	return text.String()
+}
+
+func TestRenderSVG(t *testing.T) {
+	useAvatar(t, svgAvatar)
+	game := svgGame()
+	ranking := buildGameState(game, nil).Snakes
+
+	for _, tt := range []struct {
+		name string
+		opts RenderOptions
+	}{
+		{"blocks with scoreboard", RenderOptions{Highlight: true, Scoreboard: scoreboardSide, Ranking: ranking}},
+		{"sprites with avatar head", RenderOptions{Highlight: true, Scoreboard: scoreboardTop, Ranking: ranking, SnakeStyle: snakeStyleSprites, AvatarHead: true}},
+		{"viewport with mini map", RenderOptions{Highlight: true, Scoreboard: scoreboardSide, Ranking: ranking,
+			viewport: viewport{Target: viewportViewer, Cols: 5, Rows: 5, MiniMap: true}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data, contentType, err := renderSVG(game, "viewer", tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contentType != "image/svg+xml" {
+				t.Errorf("content type %q", contentType)
+			}
+			text := svgText(t, data)
+			// 昵称经过转义，解析后还原为原来的文字
+			for _, name := range []string{`<i>"A&B"`, `O'Neil`} {
+				if !strings.Contains(text, name) {
+					t.Errorf("nickname %q is missing from the text %q", name, text)
+				}
+			}
+			if strings.Contains(string(data), "<i>") {
+				t.Error("nickname is not escaped")
+			}
+			// 图片只通过data URI和id引用，文件名不会写入属性
+			if strings.Contains(string(data), "<script>") || strings.Contains(string(data), "missing.png") {
+				t.Error("image names are written into the SVG")
+			}
+			if !strings.Contains(string(data), `href="data:image/png;base64,`) {
+				t.Error("avatar is not embedded as a data URI")
+			}
+		})
+	}
+}
+
+func TestSVGColor(t *testing.T) {
+	if got := svgColor(color.NRGBA{R: 0x12, G: 0xab, B: 0xff, A: 255}); got != "#12abff" {
+		t.Errorf("opaque color %q", got)
+	}
+	if got := svgColor(color.NRGBA{R: 1, G: 2, B: 3, A: 0x80}); got != "#01020380" {
+		t.Errorf("translucent color %q", got)
+	}
+}
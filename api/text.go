@@ -0,0 +1,142 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hoshinonyaruko/snake-in-im/config"
+	"github.com/hoshinonyaruko/snake-in-im/snake"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// 输出方式
+const (
+	renderModeImage = "image" // 只输出图片
+	renderModeText  = "text"  // 只输出文字地图，不渲染图片
+	renderModeBoth  = "both"  // 同时输出图片和文字地图
+)
+
+// validRenderMode 检查输出方式
+func validRenderMode(mode string) bool {
+	return mode == renderModeImage || mode == renderModeText || mode == renderModeBoth
+}
+
+// lookupTextGlyphs 按名称查找文字地图的字符风格
+func lookupTextGlyphs(style string) (config.TextGlyphs, error) {
+	glyphs, ok := config.GetConfigValue("textglyphs").(map[string]config.TextGlyphs)[style]
+	if !ok || glyphs.Empty == "" || len(glyphs.Heads) == 0 || len(glyphs.Bodies) == 0 {
+		return config.TextGlyphs{}, fmt.Errorf("unsupported text style %q", style)
+	}
+	return glyphs, nil
+}
+
+// renderText 把地图渲染为文字，供不能发送图片的渠道使用，返回地图和图例
+// 与图片使用相同的视口，观看者的蛇总是使用第一组字符并画在最上层
+func renderText(game *structs.Game, view renderView, glyphs config.TextGlyphs) (string, string) {
+	gameMap := &game.Map
+	local := view.Viewport.window(gameMap, view.Viewer).apply(gameMap)
+
+	grid := make([][]string, local.Height)
+	for y := range grid {
+		grid[y] = make([]string, local.Width)
+		for x := range grid[y] {
+			grid[y][x] = glyphs.Empty
+		}
+	}
+	put := func(pos structs.Position, glyph string) {
+		if pos.X >= 0 && pos.X < local.Width && pos.Y >= 0 && pos.Y < local.Height {
+			grid[pos.Y][pos.X] = glyph
+		}
+	}
+
+	// 旧的配置文件中没有墙和障碍物的字符
+	wall, obstacle := glyphs.Wall, glyphs.Obstacle
+	if wall == "" {
+		wall = "#"
+	}
+	if obstacle == "" {
+		obstacle = "%"
+	}
+	kinds := make(map[string]bool)
+	for _, tile := range local.Tiles {
+		switch tile.Kind {
+		case structs.TileWall:
+			put(structs.Position{X: tile.X, Y: tile.Y}, wall)
+		case structs.TileObstacle:
+			put(structs.Position{X: tile.X, Y: tile.Y}, obstacle)
+		}
+		kinds[tile.Kind] = true
+	}
+
+	for _, food := range local.Food {
+		put(food, glyphs.Food)
+	}
+
+	// 观看者排在第一位，其余的蛇按OpenID排序
+	order := []string{}
+	if _, ok := local.Snakes[view.Viewer]; ok {
+		order = append(order, view.Viewer)
+	}
+	for _, id := range snake.SortedSnakeIDs(local.Snakes) {
+		if id != view.Viewer {
+			order = append(order, id)
+		}
+	}
+
+	var legend []string
+	for i := len(order) - 1; i >= 0; i-- {
+		s := local.Snakes[order[i]]
+		head := glyphs.Heads[i%len(glyphs.Heads)]
+		body := glyphs.Bodies[i%len(glyphs.Bodies)]
+		visible := false
+		// 从尾部画到头部，蛇头不会被自己的身体盖住
+		for j := len(s.Positions) - 1; j >= 0; j-- {
+			pos := s.Positions[j]
+			if pos.X < local.Width && pos.Y < local.Height {
+				visible = true
+			}
+			if j == 0 {
+				put(pos, head)
+			} else {
+				put(pos, body)
+			}
+		}
+		if !visible {
+			continue
+		}
+
+		name := displayName(structs.SnakeState{OpenID: s.OpenID, Nickname: game.Nicknames[s.OpenID]})
+		if s.OpenID == view.Viewer {
+			name += "（你）"
+		}
+		legend = append([]string{fmt.Sprintf("%s %s 长度%d%s", head, name, len(s.Positions), effectLabels(s))}, legend...)
+	}
+	if len(local.Food) > 0 {
+		legend = append(legend, fmt.Sprintf("%s 食物", glyphs.Food))
+	}
+	if kinds[structs.TileWall] {
+		legend = append(legend, fmt.Sprintf("%s 墙", wall))
+	}
+	if kinds[structs.TileObstacle] {
+		legend = append(legend, fmt.Sprintf("%s 障碍物", obstacle))
+	}
+
+	var b strings.Builder
+	if glyphs.Border {
+		b.WriteString("┌" + strings.Repeat("─", local.Width) + "┐\n")
+	}
+	for _, row := range grid {
+		if glyphs.Border {
+			b.WriteString("│")
+		}
+		b.WriteString(strings.Join(row, ""))
+		if glyphs.Border {
+			b.WriteString("│")
+		}
+		b.WriteString("\n")
+	}
+	if glyphs.Border {
+		b.WriteString("└" + strings.Repeat("─", local.Width) + "┘\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n"), strings.Join(legend, "\n")
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hoshinonyaruko/snake-in-im/config"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// plainGlyphs 每个格子一个ASCII字符，便于比较
+var plainGlyphs = config.TextGlyphs{
+	Empty:    ".",
+	Food:     "*",
+	Wall:     "#",
+	Obstacle: "%",
+	Heads:    []string{"A", "B"},
+	Bodies:   []string{"a", "b"},
+}
+
+// textGame 5×3的地图，观看者zed排在amy之后，但使用第一组字符
+func textGame() *structs.Game {
+	return &structs.Game{
+		Nicknames: map[string]string{"zed": "小Z"},
+		Map: structs.GameMap{
+			Width:  5,
+			Height: 3,
+			Snakes: map[string]structs.Snake{
+				"zed": {OpenID: "zed", Direction: "left", SpeedUp: 3, Positions: []structs.Position{{X: 1, Y: 1}, {X: 2, Y: 1}}},
+				"amy": {OpenID: "amy", Direction: "up", Positions: []structs.Position{{X: 4, Y: 0}, {X: 4, Y: 1}}},
+			},
+			Food:  []structs.Position{{X: 0, Y: 2}},
+			Tiles: []structs.Tile{{X: 0, Y: 0, Kind: structs.TileWall}, {X: 3, Y: 2, Kind: structs.TileObstacle}},
+		},
+	}
+}
+
+func TestRenderText(t *testing.T) {
+	text, legend := renderText(textGame(), renderView{Viewer: "zed"}, plainGlyphs)
+	wantText := "#...B\n" +
+		".Aa.b\n" +
+		"*..%."
+	if text != wantText {
+		t.Errorf("text:\n%s\nwant:\n%s", text, wantText)
+	}
+	wantLegend := "A 小Z（你） 长度2 加速3\n" +
+		"B amy 长度2\n" +
+		"* 食物\n" +
+		"# 墙\n" +
+		"% 障碍物"
+	if legend != wantLegend {
+		t.Errorf("legend:\n%s\nwant:\n%s", legend, wantLegend)
+	}
+
+	// 不在地图上的观看者不占用第一组字符
+	text, legend = renderText(textGame(), renderView{Viewer: "bob"}, plainGlyphs)
+	if !strings.HasPrefix(text, "#...A\n.Bb.a") || !strings.HasPrefix(legend, "A amy 长度2\nB 小Z 长度2") {
+		t.Errorf("spectator view:\n%s\n%s", text, legend)
+	}
+}
+
+func TestRenderTextViewerOnTop(t *testing.T) {
+	game := textGame()
+	// amy的身体与观看者的蛇头重叠时，观看者画在最上层
+	game.Map.Snakes["amy"] = structs.Snake{OpenID: "amy", Positions: []structs.Position{{X: 1, Y: 0}, {X: 1, Y: 1}, {X: 1, Y: 2}}}
+	text, _ := renderText(game, renderView{Viewer: "zed"}, plainGlyphs)
+	if rows := strings.Split(text, "\n"); rows[1][1] != 'A' {
+		t.Errorf("viewer head is hidden:\n%s", text)
+	}
+}
+
+func TestRenderTextBorder(t *testing.T) {
+	glyphs := plainGlyphs
+	glyphs.Border = true
+	text, _ := renderText(textGame(), renderView{Viewer: "zed"}, glyphs)
+	want := "┌─────┐\n" +
+		"│#...B│\n" +
+		"│.Aa.b│\n" +
+		"│*..%.│\n" +
+		"└─────┘"
+	if text != want {
+		t.Errorf("text:\n%s\nwant:\n%s", text, want)
+	}
+}
+
+func TestRenderTextViewport(t *testing.T) {
+	game := &structs.Game{
+		Map: structs.GameMap{
+			Width:  20,
+			Height: 20,
+			Snakes: map[string]structs.Snake{
+				// 观看者的身体跨过地图的左边缘
+				"zed": {OpenID: "zed", Direction: "right", Positions: []structs.Position{{X: 0, Y: 10}, {X: 19, Y: 10}}},
+				"far": {OpenID: "far", Direction: "up", Positions: []structs.Position{{X: 10, Y: 0}}},
+			},
+			Food:  []structs.Position{{X: 1, Y: 11}, {X: 10, Y: 10}},
+			Tiles: []structs.Tile{{X: 15, Y: 15, Kind: structs.TileWall}},
+		},
+	}
+	view := renderView{Viewer: "zed", Viewport: viewport{Target: viewportViewer, Cols: 5, Rows: 3}}
+	text, legend := renderText(game, view, plainGlyphs)
+	want := ".....\n" +
+		".aA..\n" +
+		"...*."
+	if text != want {
+		t.Errorf("text:\n%s\nwant:\n%s", text, want)
+	}
+	// 区域外的蛇、食物和墙不出现在图例中
+	if legend != "A zed（你） 长度2\n* 食物" {
+		t.Errorf("legend:\n%s", legend)
+	}
+}
+
+func TestLookupTextGlyphs(t *testing.T) {
+	for _, style := range []string{"emoji", "box"} {
+		glyphs, err := lookupTextGlyphs(style)
+		if err != nil {
+			t.Fatal(err)
+		}
+		configured := config.GetConfigValue("textglyphs").(map[string]config.TextGlyphs)[style]
+		text, legend := renderText(textGame(), renderView{Viewer: "zed"}, glyphs)
+		if !strings.Contains(text, configured.Heads[0]) || !strings.Contains(text, configured.Food) || !strings.Contains(text, configured.Wall) {
+			t.Errorf("%s: configured glyphs are not used:\n%s", style, text)
+		}
+		if !strings.HasPrefix(legend, configured.Heads[0]+" 小Z（你）") {
+			t.Errorf("%s: legend %q", style, legend)
+		}
+		if configured.Border != strings.HasPrefix(text, "┌") {
+			t.Errorf("%s: border %v, text:\n%s", style, configured.Border, text)
+		}
+	}
+	if _, err := lookupTextGlyphs("braille"); err == nil {
+		t.Error("unknown text style was accepted")
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"github.com/fogleman/gg"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+	"github.com/hoshinonyaruko/snake-in-im/theme"
+)
+
+// renderView 与观看者有关的渲染选项
+type renderView struct {
+	Viewer     string       // 观看者的OpenID
+	Direction  string       // 观看者本次设置的方向，为空时只查看
+	Highlight  bool         // 突出显示观看者的蛇
+	Viewport   viewport     // 大地图只渲染其中的一块区域
+	Style      string       // 蛇的画法，见snakeStyleBlocks等
+	AvatarHead bool         // 使用精灵图时是否在蛇头上画出玩家头像
+	Theme      *theme.Theme // 棋盘的外观
+}
+
+// youLabel 观看者蛇头上方的标记
+const youLabel = "你"
+
+// arrowDirection 返回蛇头箭头应当指示的方向
+// 观看者本次改变了方向时显示新方向，其他蛇显示当前方向
+func (v renderView) arrowDirection(s structs.Snake) string {
+	if v.Direction != "" && s.OpenID == v.Viewer {
+		return v.Direction
+	}
+	return s.Direction
+}
+
+// drawSnake 按选择的画法画一条蛇
+func (v renderView) drawSnake(dc *gg.Context, s structs.Snake, blockSize int) {
+	if v.Style == snakeStyleSprites {
+		drawSpriteSnake(dc, s, blockSize, v.arrowDirection(s), v.AvatarHead, v.Theme)
+		return
+	}
+	drawSnake(dc, s, blockSize, v.arrowDirection(s), v.Theme)
+}
+
+// drawGlow 在观看者的蛇下方画出半透明的光晕，需要在画蛇之前调用
+func drawGlow(dc *gg.Context, s structs.Snake, blockSize int, th *theme.Theme) {
+	spread := float64(blockSize) / 5
+	c := th.Highlight
+	dc.SetRGBA(float64(c.R)/255, float64(c.G)/255, float64(c.B)/255, 0.45)
+	for _, pos := range s.Positions {
+		dc.DrawRoundedRectangle(float64(pos.X*blockSize)-spread, float64(pos.Y*blockSize)-spread,
+			float64(blockSize)+spread*2, float64(blockSize)+spread*2, spread)
+	}
+	dc.Fill()
+}
+
+// drawOutline 在观看者的蛇的每一节外面描边，并在蛇头上方标出"你"
+func drawOutline(dc *gg.Context, s structs.Snake, blockSize int, th *theme.Theme) {
+	if len(s.Positions) == 0 {
+		return
+	}
+	dc.SetColor(th.Highlight)
+	dc.SetLineWidth(2)
+	for _, pos := range s.Positions {
+		dc.DrawRectangle(float64(pos.X*blockSize)+1, float64(pos.Y*blockSize)+1, float64(blockSize)-2, float64(blockSize)-2)
+	}
+	dc.Stroke()
+	dc.SetLineWidth(1)
+
+	// 标记放在蛇头上方，蛇头在第一行时放在下方
+	head := s.Positions[0]
+	dc.SetFontFace(th.FontFace())
+	w, h := dc.MeasureString(youLabel)
+	x := float64(head.X*blockSize + blockSize/2)
+	y := float64(head.Y*blockSize) - h/2 - 3
+	if head.Y == 0 {
+		y = float64((head.Y+1)*blockSize) + h/2 + 3
+	}
+	dc.SetColor(th.Highlight)
+	dc.DrawRoundedRectangle(x-w/2-3, y-h/2-2, w+6, h+4, 3)
+	dc.Fill()
+	dc.SetRGB(0, 0, 0)
+	dc.DrawStringAnchored(youLabel, x, y, 0.5, 0.5)
+}
@@ -0,0 +1,301 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"github.com/gin-gonic/gin"
+	"github.com/hoshinonyaruko/snake-in-im/config"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+	"github.com/hoshinonyaruko/snake-in-im/theme"
+)
+
+// 视口的目标
+const (
+	viewportNone    = "none"    // 渲染整张地图
+	viewportViewer  = "viewer"  // 以观看者的蛇头为中心，观看者不在地图上时使用busiest
+	viewportBusiest = "busiest" // 蛇和食物最多的区域
+	viewportPoint   = "point"   // 以指定的格子为中心
+)
+
+// viewport 大地图只渲染其中的一块区域，并在角落画出整张地图的缩略图
+type viewport struct {
+	Target  string // 视口的目标
+	X, Y    int    // Target为point时的中心格子
+	Cols    int    // 视口宽度（格数）
+	Rows    int    // 视口高度（格数）
+	MiniMap bool   // 是否绘制缩略图
+	Auto    int    // Target为none且地图的宽或高超过Auto格时，自动以观看者为中心，0为不自动
+}
+
+// parseViewport 解析视口参数，取值为none、viewer、busiest或"x,y"格式的坐标
+func parseViewport(value string) (viewport, error) {
+	switch value {
+	case viewportNone, viewportViewer, viewportBusiest:
+		return viewport{Target: value}, nil
+	}
+	parts := strings.Split(value, ",")
+	if len(parts) == 2 {
+		x, errX := strconv.Atoi(strings.TrimSpace(parts[0]))
+		y, errY := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if errX == nil && errY == nil && x >= 0 && y >= 0 {
+			return viewport{Target: viewportPoint, X: x, Y: y}, nil
+		}
+	}
+	return viewport{}, fmt.Errorf("unsupported viewport %q", value)
+}
+
+// viewportFromQuery 从请求参数中读取视口，未指定的参数使用配置
+// center=1是viewport=viewer的简写，center_size同时设置视口的宽和高
+func viewportFromQuery(c *gin.Context) (viewport, error) {
+	value, explicit := c.GetQuery("viewport")
+	if !explicit || value == "" {
+		value = config.GetConfigValue("viewport").(string)
+		explicit = false
+	}
+	if center := c.Query("center"); center == "1" || center == "true" {
+		value, explicit = viewportViewer, true
+	}
+	v, err := parseViewport(value)
+	if err != nil {
+		return v, err
+	}
+	if !explicit {
+		v.Auto = config.GetConfigValue("viewportauto").(int)
+	}
+
+	v.Cols = config.GetConfigValue("viewportwidth").(int)
+	v.Rows = config.GetConfigValue("viewportheight").(int)
+	if size, err := strconv.Atoi(c.Query("center_size")); err == nil && size > 0 {
+		v.Cols, v.Rows = size, size
+	}
+	if cols, err := strconv.Atoi(c.Query("viewport_width")); err == nil && cols > 0 {
+		v.Cols = cols
+	}
+	if rows, err := strconv.Atoi(c.Query("viewport_height")); err == nil && rows > 0 {
+		v.Rows = rows
+	}
+
+	v.MiniMap = config.GetConfigValue("minimap").(bool)
+	if miniMap := c.Query("minimap"); miniMap != "" {
+		v.MiniMap = miniMap == "1" || miniMap == "true"
+	}
+	return v, nil
+}
+
+// viewWindow 地图上被渲染的区域，左上角为(X, Y)，超出地图边缘时从另一侧接上
+type viewWindow struct {
+	X, Y       int
+	Cols, Rows int
+}
+
+// window 计算视口在地图上的区域，视口覆盖整张地图时返回整张地图
+func (v viewport) window(gameMap *structs.GameMap, viewer string) viewWindow {
+	full := viewWindow{Cols: gameMap.Width, Rows: gameMap.Height}
+	target := v.Target
+	if (target == "" || target == viewportNone) && v.Auto > 0 && (gameMap.Width > v.Auto || gameMap.Height > v.Auto) {
+		target = viewportViewer
+	}
+	if target == "" || target == viewportNone || gameMap.Width <= 0 || gameMap.Height <= 0 {
+		return full
+	}
+	cols, rows := v.Cols, v.Rows
+	if cols <= 0 || cols > gameMap.Width {
+		cols = gameMap.Width
+	}
+	if rows <= 0 || rows > gameMap.Height {
+		rows = gameMap.Height
+	}
+	if cols == gameMap.Width && rows == gameMap.Height {
+		return full
+	}
+
+	cx, cy := v.X, v.Y
+	if target == viewportViewer {
+		if s, ok := gameMap.Snakes[viewer]; ok && len(s.Positions) > 0 {
+			cx, cy = s.Positions[0].X, s.Positions[0].Y
+		} else {
+			target = viewportBusiest
+		}
+	}
+	if target == viewportBusiest {
+		x, y := busiestWindow(gameMap, cols, rows)
+		return viewWindow{X: x, Y: y, Cols: cols, Rows: rows}
+	}
+	return viewWindow{
+		X:    wrapIndex(cx-cols/2, gameMap.Width),
+		Y:    wrapIndex(cy-rows/2, gameMap.Height),
+		Cols: cols,
+		Rows: rows,
+	}
+}
+
+// cropped 区域是否只是地图的一部分
+func (w viewWindow) cropped(gameMap *structs.GameMap) bool {
+	return w.Cols != gameMap.Width || w.Rows != gameMap.Height
+}
+
+// apply 把地图坐标换算为区域内的坐标，返回以区域为大小的地图
+// 区域外的食物和关卡格子被丢弃；蛇保留所有的节，区域外的节落在画布之外，蛇头始终是第一节
+func (w viewWindow) apply(gameMap *structs.GameMap) *structs.GameMap {
+	if !w.cropped(gameMap) {
+		return gameMap
+	}
+	local := &structs.GameMap{
+		Width:  w.Cols,
+		Height: w.Rows,
+		Snakes: make(map[string]structs.Snake, len(gameMap.Snakes)),
+		Food:   []structs.Position{},
+	}
+	for _, food := range gameMap.Food {
+		food.X, food.Y = w.local(food.X, food.Y, gameMap)
+		if food.X < w.Cols && food.Y < w.Rows {
+			local.Food = append(local.Food, food)
+		}
+	}
+	for _, tile := range gameMap.Tiles {
+		tile.X, tile.Y = w.local(tile.X, tile.Y, gameMap)
+		if tile.X < w.Cols && tile.Y < w.Rows {
+			local.Tiles = append(local.Tiles, tile)
+		}
+	}
+	for id, s := range gameMap.Snakes {
+		positions := make([]structs.Position, len(s.Positions))
+		for i, pos := range s.Positions {
+			pos.X, pos.Y = w.local(pos.X, pos.Y, gameMap)
+			positions[i] = pos
+		}
+		s.Positions = positions
+		local.Snakes[id] = s
+	}
+	return local
+}
+
+// local 把地图坐标换算为区域内的坐标
+func (w viewWindow) local(x, y int, gameMap *structs.GameMap) (int, int) {
+	return wrapIndex(x-w.X, gameMap.Width), wrapIndex(y-w.Y, gameMap.Height)
+}
+
+// wrapIndex 把坐标折回[0, size)范围内
+func wrapIndex(i, size int) int {
+	i %= size
+	if i < 0 {
+		i += size
+	}
+	return i
+}
+
+// busiestWindow 返回包含蛇身和食物最多的区域的左上角，数量相同时取最靠上、靠左的区域
+func busiestWindow(gameMap *structs.GameMap, cols, rows int) (int, int) {
+	width, height := gameMap.Width, gameMap.Height
+	counts := make([]int, width*height)
+	for _, s := range gameMap.Snakes {
+		for _, pos := range s.Positions {
+			counts[wrapIndex(pos.Y, height)*width+wrapIndex(pos.X, width)]++
+		}
+	}
+	for _, food := range gameMap.Food {
+		counts[wrapIndex(food.Y, height)*width+wrapIndex(food.X, width)]++
+	}
+
+	// 在两倍大小的地图上计算前缀和，跨越边缘的区域也能直接求和
+	stride := width*2 + 1
+	prefix := make([]int, (height*2+1)*stride)
+	for y := 0; y < height*2; y++ {
+		for x := 0; x < width*2; x++ {
+			prefix[(y+1)*stride+x+1] = counts[(y%height)*width+x%width] +
+				prefix[y*stride+x+1] + prefix[(y+1)*stride+x] - prefix[y*stride+x]
+		}
+	}
+
+	bestX, bestY, best := 0, 0, -1
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sum := prefix[(y+rows)*stride+x+cols] - prefix[y*stride+x+cols] - prefix[(y+rows)*stride+x] + prefix[y*stride+x]
+			if sum > best {
+				bestX, bestY, best = x, y, sum
+			}
+		}
+	}
+	return bestX, bestY
+}
+
+// drawMiniMap 在画面右下角画出整张地图的缩略图，并用红框标出当前区域，观看者使用主题的突出颜色
+func drawMiniMap(dc *gg.Context, gameMap *structs.GameMap, w viewWindow, viewer string, size int, th *theme.Theme) {
+	longest := gameMap.Width
+	if gameMap.Height > longest {
+		longest = gameMap.Height
+	}
+	// 缩略图不超过画面的一半
+	if limit := dc.Width() / 2; size > limit {
+		size = limit
+	}
+	if limit := dc.Height() / 2; size > limit {
+		size = limit
+	}
+	if longest <= 0 || size <= 0 {
+		return
+	}
+	cell := float64(size) / float64(longest)
+	mapWidth := cell * float64(gameMap.Width)
+	mapHeight := cell * float64(gameMap.Height)
+	const margin = 4
+	left := float64(dc.Width()) - mapWidth - margin
+	top := float64(dc.Height()) - mapHeight - margin
+
+	dc.Push()
+	defer dc.Pop()
+
+	dc.SetRGBA(0, 0, 0, 0.6)
+	dc.DrawRectangle(left, top, mapWidth, mapHeight)
+	dc.Fill()
+
+	dot := func(pos structs.Position) {
+		dc.DrawRectangle(left+float64(pos.X)*cell, top+float64(pos.Y)*cell, cell, cell)
+	}
+	dc.SetRGB(0.5, 0.5, 0.5)
+	for _, tile := range gameMap.Tiles {
+		if tile.Kind == structs.TileWall || tile.Kind == structs.TileObstacle {
+			dot(structs.Position{X: tile.X, Y: tile.Y})
+		}
+	}
+	dc.Fill()
+	dc.SetRGB(0.4, 0.9, 0.4)
+	for _, food := range gameMap.Food {
+		dot(food)
+	}
+	dc.Fill()
+	dc.SetRGB(1, 1, 1)
+	for id, s := range gameMap.Snakes {
+		if id == viewer {
+			continue
+		}
+		for _, pos := range s.Positions {
+			dot(pos)
+		}
+	}
+	dc.Fill()
+	if s, ok := gameMap.Snakes[viewer]; ok {
+		dc.SetColor(th.Highlight)
+		for _, pos := range s.Positions {
+			dot(pos)
+		}
+		dc.Fill()
+	}
+
+	// 区域可能跨越地图边缘，在相邻的位置各画一次，再裁剪到缩略图内
+	dc.DrawRectangle(left, top, mapWidth, mapHeight)
+	dc.Clip()
+	dc.SetRGB(1, 0.2, 0.2)
+	dc.SetLineWidth(1)
+	for i := -1; i <= 0; i++ {
+		for j := -1; j <= 0; j++ {
+			dc.DrawRectangle(left+float64(w.X+i*gameMap.Width)*cell, top+float64(w.Y+j*gameMap.Height)*cell,
+				float64(w.Cols)*cell, float64(w.Rows)*cell)
+		}
+	}
+	dc.Stroke()
+	dc.ResetClip()
+}
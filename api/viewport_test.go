@@ -0,0 +1,166 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fogleman/gg"
+	"github.com/gin-gonic/gin"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+	"github.com/hoshinonyaruko/snake-in-im/theme"
+)
+
+func TestParseViewport(t *testing.T) {
+	tests := []struct {
+		value string
+		want  viewport
+		err   bool
+	}{
+		{"none", viewport{Target: viewportNone}, false},
+		{"viewer", viewport{Target: viewportViewer}, false},
+		{"busiest", viewport{Target: viewportBusiest}, false},
+		{"3,4", viewport{Target: viewportPoint, X: 3, Y: 4}, false},
+		{" 3 , 4 ", viewport{Target: viewportPoint, X: 3, Y: 4}, false},
+		{"-1,4", viewport{}, true},
+		{"3", viewport{}, true},
+		{"left", viewport{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseViewport(tt.value)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseViewport(%q) = %+v, %v", tt.value, got, err)
+		}
+	}
+}
+
+func TestViewportFromQuery(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/render-map?center=1&center_size=7&viewport_height=5&minimap=0", nil)
+	v, err := viewportFromQuery(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Target != viewportViewer || v.Cols != 7 || v.Rows != 5 || v.MiniMap || v.Auto != 0 {
+		t.Errorf("viewport %+v", v)
+	}
+
+	// 没有指定视口时按地图大小自动选择
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/render-map", nil)
+	if v, err := viewportFromQuery(c); err != nil || v.Target != viewportNone || v.Auto <= 0 {
+		t.Errorf("default viewport %+v, %v", v, err)
+	}
+}
+
+// windowMap 20×20的地图，alice的蛇头在左上角，身体跨过上边缘
+func windowMap() *structs.GameMap {
+	return &structs.GameMap{
+		Width:  20,
+		Height: 20,
+		Snakes: map[string]structs.Snake{
+			"alice": {OpenID: "alice", Direction: "down", Positions: []structs.Position{{X: 1, Y: 0}, {X: 1, Y: 19}, {X: 1, Y: 18}}},
+			"bob":   {OpenID: "bob", Direction: "left", Positions: []structs.Position{{X: 12, Y: 12}, {X: 13, Y: 12}}},
+		},
+		Food: []structs.Position{{X: 19, Y: 1}, {X: 10, Y: 10}},
+	}
+}
+
+func TestViewportWindow(t *testing.T) {
+	gameMap := windowMap()
+	tests := []struct {
+		name   string
+		v      viewport
+		viewer string
+		want   viewWindow
+	}{
+		{"none is the full map", viewport{Target: viewportNone, Cols: 5, Rows: 5}, "alice", viewWindow{Cols: 20, Rows: 20}},
+		{"larger than the map", viewport{Target: viewportViewer, Cols: 30, Rows: 30}, "alice", viewWindow{Cols: 20, Rows: 20}},
+		{"centred on the viewer across both edges", viewport{Target: viewportViewer, Cols: 5, Rows: 5}, "alice", viewWindow{X: 19, Y: 18, Cols: 5, Rows: 5}},
+		{"centred on a point", viewport{Target: viewportPoint, X: 10, Y: 10, Cols: 6, Rows: 4}, "", viewWindow{X: 7, Y: 8, Cols: 6, Rows: 4}},
+		{"point across the right edge", viewport{Target: viewportPoint, X: 19, Y: 10, Cols: 6, Rows: 4}, "", viewWindow{X: 16, Y: 8, Cols: 6, Rows: 4}},
+		{"missing viewer falls back to busiest", viewport{Target: viewportViewer, Cols: 3, Rows: 3}, "nobody", viewWindow{X: 0, Y: 18, Cols: 3, Rows: 3}},
+		{"auto on large maps", viewport{Target: viewportNone, Auto: 10, Cols: 5, Rows: 5}, "bob", viewWindow{X: 10, Y: 10, Cols: 5, Rows: 5}},
+		{"no auto on small maps", viewport{Target: viewportNone, Auto: 20, Cols: 5, Rows: 5}, "bob", viewWindow{Cols: 20, Rows: 20}},
+	}
+	for _, tt := range tests {
+		if got := tt.v.window(gameMap, tt.viewer); got != tt.want {
+			t.Errorf("%s: window %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestViewWindowApply(t *testing.T) {
+	gameMap := windowMap()
+	w := viewport{Target: viewportViewer, Cols: 5, Rows: 5}.window(gameMap, "alice")
+	local := w.apply(gameMap)
+
+	if local.Width != 5 || local.Height != 5 {
+		t.Fatalf("local map is %dx%d, want 5x5", local.Width, local.Height)
+	}
+	// 蛇头在区域中心，跨过边缘的身体接在蛇头上方
+	want := []structs.Position{{X: 2, Y: 2}, {X: 2, Y: 1}, {X: 2, Y: 0}}
+	alice := local.Snakes["alice"].Positions
+	for i := range want {
+		if alice[i] != want[i] {
+			t.Fatalf("alice at %v, want %v", alice, want)
+		}
+	}
+	// 右边缘的食物出现在区域的左侧，区域外的食物被丢弃
+	if len(local.Food) != 1 || local.Food[0].X != 0 || local.Food[0].Y != 3 {
+		t.Errorf("local food %v, want one at (0, 3)", local.Food)
+	}
+	// 区域外的蛇保留，但落在画布之外
+	if bob := local.Snakes["bob"].Positions[0]; bob.X < 5 && bob.Y < 5 {
+		t.Errorf("bob at %v is inside the window", bob)
+	}
+	// 原来的地图不变
+	if gameMap.Snakes["alice"].Positions[0] != (structs.Position{X: 1, Y: 0}) || gameMap.Food[0].X != 19 {
+		t.Error("apply modified the game map")
+	}
+
+	full := viewWindow{Cols: 20, Rows: 20}
+	if full.apply(gameMap) != gameMap {
+		t.Error("a full window should return the map itself")
+	}
+}
+
+func TestDrawMiniMapPlacement(t *testing.T) {
+	gameMap := &structs.GameMap{
+		Width:  40,
+		Height: 20,
+		Snakes: map[string]structs.Snake{
+			"alice": {OpenID: "alice", Positions: []structs.Position{{X: 0, Y: 0}}},
+		},
+	}
+	dc := gg.NewContext(200, 100)
+	th := theme.Default()
+	drawMiniMap(dc, gameMap, viewWindow{X: 20, Y: 10, Cols: 10, Rows: 5}, "alice", 120, th)
+	img := dc.Image()
+
+	alpha := func(x, y int) uint32 {
+		_, _, _, a := img.At(x, y).RGBA()
+		return a
+	}
+	// 缩略图不超过画面的一半：50×25，距离右下角4像素，左上角在(146, 71)
+	for _, p := range [][2]int{{147, 72}, {195, 95}} {
+		if alpha(p[0], p[1]) == 0 {
+			t.Errorf("(%d, %d) is outside the mini map", p[0], p[1])
+		}
+	}
+	for _, p := range [][2]int{{144, 80}, {170, 69}, {197, 80}, {170, 97}, {10, 10}} {
+		if alpha(p[0], p[1]) != 0 {
+			t.Errorf("(%d, %d) is covered by the mini map", p[0], p[1])
+		}
+	}
+	// 观看者使用主题的突出颜色
+	r, g, b, _ := img.At(146, 71).RGBA()
+	hr, hg, hb, _ := th.Highlight.RGBA()
+	if r>>8 != hr>>8 || g>>8 != hg>>8 || b>>8 != hb>>8 {
+		t.Errorf("viewer is drawn as %v, want %v", img.At(146, 71), th.Highlight)
+	}
+	// 红框标出视口，左上角在缩略图的(20, 10)格
+	if r, g, _, _ := img.At(175, 83).RGBA(); r <= g {
+		t.Errorf("viewport frame is missing: %v", img.At(175, 83))
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"github.com/fogleman/gg"
+	"github.com/hoshinonyaruko/snake-in-im/snake"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+	"github.com/hoshinonyaruko/snake-in-im/theme"
+)
+
+// edgeLines 返回区域内与地图边缘重合的网格线，xs为竖线、ys为横线，单位为格
+// 边缘相连的地图没有墙，返回空
+func edgeLines(gameMap *structs.GameMap, w viewWindow) (xs, ys []int) {
+	if snake.Wraps(gameMap) {
+		return nil, nil
+	}
+	for k := 0; k <= w.Cols; k++ {
+		if (w.X+k)%gameMap.Width == 0 {
+			xs = append(xs, k)
+		}
+	}
+	for k := 0; k <= w.Rows; k++ {
+		if (w.Y+k)%gameMap.Height == 0 {
+			ys = append(ys, k)
+		}
+	}
+	return xs, ys
+}
+
+// wallWidth 墙的线宽
+func wallWidth(blockSize int) float64 {
+	return float64(blockSize) / 4
+}
+
+// drawWalls 在地图边缘画出墙，视口跨越边缘时墙画在区域中间
+func drawWalls(dc *gg.Context, gameMap *structs.GameMap, w viewWindow, blockSize int, th *theme.Theme) {
+	xs, ys := edgeLines(gameMap, w)
+	if len(xs) == 0 && len(ys) == 0 {
+		return
+	}
+	dc.Push()
+	defer dc.Pop()
+	dc.SetColor(th.Wall)
+	dc.SetLineWidth(wallWidth(blockSize))
+	height := float64(w.Rows * blockSize)
+	width := float64(w.Cols * blockSize)
+	for _, x := range xs {
+		dc.DrawLine(float64(x*blockSize), 0, float64(x*blockSize), height)
+	}
+	for _, y := range ys {
+		dc.DrawLine(0, float64(y*blockSize), width, float64(y*blockSize))
+	}
+	dc.Stroke()
+}
+
+// drawTiles 画出关卡中的墙和障碍物，墙填满整个格子，障碍物为略小的圆角方块；出生区域不画
+func drawTiles(dc *gg.Context, tiles []structs.Tile, blockSize int, th *theme.Theme) {
+	if len(tiles) == 0 {
+		return
+	}
+	dc.Push()
+	defer dc.Pop()
+	bs := float64(blockSize)
+	for _, tile := range tiles {
+		x, y := float64(tile.X)*bs, float64(tile.Y)*bs
+		switch tile.Kind {
+		case structs.TileWall:
+			dc.SetColor(th.Wall)
+			dc.DrawRectangle(x, y, bs, bs)
+			dc.Fill()
+		case structs.TileObstacle:
+			inset := bs / 10
+			dc.SetColor(th.Obstacle)
+			dc.DrawRoundedRectangle(x+inset, y+inset, bs-2*inset, bs-2*inset, bs/4)
+			dc.Fill()
+		}
+	}
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"encoding/json"
 	"os"
+	"strings"
 	"sync"
 )
 
@@ -11,6 +12,66 @@ type AppConfig struct {
 	SelfPath  string `json:"selfpath"`
 	Port      string `json:"port"`
 	Blocksize int    `json:"blocksize"`
+	Storage   string `json:"storage"`
+	// 图片输出格式（"jpeg", "png", "webp", "webp_lossless"）、有损格式的质量和体积上限（字节，0为不限制）
+	ImageFormat   string `json:"imageformat"`
+	ImageQuality  int    `json:"imagequality"`
+	MaxImageBytes int    `json:"maximagebytes"`
+	// 渲染结果的存放方式（"disk", "memory", "both"）和内存缓存的条数、字节数上限
+	RenderStore        string `json:"renderstore"`
+	RenderCacheEntries int    `json:"rendercacheentries"`
+	RenderCacheBytes   int    `json:"rendercachebytes"`
+	// 背景缓存的条数上限，默认64，不大于0时使用默认值
+	BackgroundCacheSize int `json:"backgroundcachesize"`
+	// 每个群组在磁盘上保留的渲染结果数量，0为不清理，最少保留2个（一次请求的图片和GIF）
+	RenderRetention int `json:"renderretention"`
+	// GIF回放的每帧时长（毫秒）和最多帧数
+	GifFrameDelay int `json:"gifframedelay"`
+	GifMaxFrames  int `json:"gifmaxframes"`
+	// 排行榜的默认位置（"none", "side", "top"）
+	Scoreboard string `json:"scoreboard"`
+	// 是否突出显示观看者的蛇
+	HighlightViewer bool `json:"highlightviewer"`
+	// 视口的默认目标（"none", "viewer", "busiest", "x,y"）和大小（格数），
+	// 地图的宽或高超过viewportauto格时自动以观看者为中心（0为不自动），以及缩略图的开关和边长（像素）
+	Viewport       string `json:"viewport"`
+	ViewportWidth  int    `json:"viewportwidth"`
+	ViewportHeight int    `json:"viewportheight"`
+	ViewportAuto   int    `json:"viewportauto"`
+	MiniMap        bool   `json:"minimap"`
+	MiniMapSize    int    `json:"minimapsize"`
+	// 默认的输出方式（"image", "text", "both"）和文字地图的字符风格，textglyphs可以覆盖或增加风格
+	RenderMode string                `json:"rendermode"`
+	TextStyle  string                `json:"textstyle"`
+	TextGlyphs map[string]TextGlyphs `json:"textglyphs"`
+	// 默认的渲染器，群组和请求没有指定时使用
+	Renderer string `json:"renderer"`
+	// 蛇的画法（"sprites", "blocks"）、精灵图的路径，以及使用精灵图时是否在蛇头上画出玩家头像
+	SnakeStyle  string `json:"snakestyle"`
+	SpriteSheet string `json:"spritesheet"`
+	AvatarHead  bool   `json:"avatarhead"`
+	// 默认的主题，群组和请求没有指定时使用，主题放在themes目录中
+	Theme string `json:"theme"`
+	// 新地图默认的边缘模式（"wrap", "solid", "bounce"）
+	Edges string `json:"edges"`
+	// 新地图默认的关卡，为空时不使用关卡，关卡放在levels目录中
+	Level string `json:"level"`
+	// 管理员的OpenID，可以放置只有管理员可以放置的食物
+	Admins []string `json:"admins"`
+	// 管理员请求需要在X-Admin-Token请求头中携带的口令，为空时不承认任何管理员
+	// openid由调用方传入，服务无法验证，只凭openid会被直接访问服务的玩家冒用
+	AdminToken string `json:"admintoken"`
+}
+
+// TextGlyphs 文字地图使用的字符，每条蛇按顺序使用Heads和Bodies中的一个字符
+type TextGlyphs struct {
+	Empty    string   `json:"empty"`    // 空格子
+	Food     string   `json:"food"`     // 食物
+	Wall     string   `json:"wall"`     // 关卡中的墙
+	Obstacle string   `json:"obstacle"` // 关卡中的障碍物
+	Heads    []string `json:"heads"`    // 蛇头
+	Bodies   []string `json:"bodies"`   // 蛇身
+	Border   bool     `json:"border"`   // 是否用制表符画出边框
 }
 
 var (
@@ -22,9 +83,58 @@ var (
 func LoadConfig(filePath string) *AppConfig {
 	once.Do(func() {
 		instance = &AppConfig{
-			SelfPath:  "http://www.example.com", // Default value
-			Port:      "38870",                  // Default value
-			Blocksize: 20,
+			SelfPath:           "http://www.example.com", // Default value
+			Port:               "38870",                  // Default value
+			Blocksize:          20,
+			Storage:            "sqlite", // "sqlite" or "memory"
+			ImageFormat:        "jpeg",
+			ImageQuality:       90,
+			MaxImageBytes:      3 * 1024 * 1024,
+			RenderStore:        "disk",
+			RenderCacheEntries: 256,
+			RenderCacheBytes:   64 * 1024 * 1024,
+			RenderRetention:    10,
+			GifFrameDelay:      300,
+			GifMaxFrames:       30,
+			Scoreboard:         "none",
+			HighlightViewer:    true,
+			Viewport:           "none",
+			ViewportWidth:      20,
+			ViewportHeight:     20,
+			ViewportAuto:       50,
+			MiniMap:            true,
+			MiniMapSize:        120,
+			RenderMode:         "image",
+			Renderer:           "raster",
+			SnakeStyle:         "sprites",
+			SpriteSheet:        "./sprites/snake.png",
+			AvatarHead:         true,
+			Theme:              "default",
+			Edges:              "wrap",
+			Level:              "",
+			Admins:             []string{},
+			TextStyle:          "emoji",
+			TextGlyphs: map[string]TextGlyphs{
+				"emoji": {
+					Empty:    "⬜",
+					Food:     "🍎",
+					Wall:     "🧱",
+					Obstacle: "🪨",
+					Heads:    []string{"🔴", "🔵", "🟢", "🟠", "🟣", "🟤", "⚫", "🟡"},
+					Bodies:   []string{"🟥", "🟦", "🟩", "🟧", "🟪", "🟫", "⬛", "🟨"},
+				},
+				"box": {
+					Empty:    "·",
+					Food:     "*",
+					Wall:     "#",
+					Obstacle: "%",
+					Heads:    strings.Split("ABCDEFGHIJKLMNOPQRSTUVWXYZ", ""),
+					Bodies:   strings.Split("abcdefghijklmnopqrstuvwxyz", ""),
+					Border:   true,
+				},
+			},
+			BackgroundCacheSize: 64,
+			AdminToken:          "",
 		}
 		// Load the config file if it exists, otherwise create one
 		if _, err := os.Stat(filePath); os.IsNotExist(err) {
@@ -73,6 +183,68 @@ func GetConfigValue(key string) interface{} {
 		return instance.Port
 	case "blocksize":
 		return instance.Blocksize
+	case "storage":
+		return instance.Storage
+	case "imageformat":
+		return instance.ImageFormat
+	case "imagequality":
+		return instance.ImageQuality
+	case "maximagebytes":
+		return instance.MaxImageBytes
+	case "renderstore":
+		return instance.RenderStore
+	case "rendercacheentries":
+		return instance.RenderCacheEntries
+	case "rendercachebytes":
+		return instance.RenderCacheBytes
+	case "backgroundcachesize":
+		return instance.BackgroundCacheSize
+	case "renderretention":
+		return instance.RenderRetention
+	case "gifframedelay":
+		return instance.GifFrameDelay
+	case "gifmaxframes":
+		return instance.GifMaxFrames
+	case "scoreboard":
+		return instance.Scoreboard
+	case "highlightviewer":
+		return instance.HighlightViewer
+	case "viewport":
+		return instance.Viewport
+	case "viewportwidth":
+		return instance.ViewportWidth
+	case "viewportheight":
+		return instance.ViewportHeight
+	case "viewportauto":
+		return instance.ViewportAuto
+	case "minimap":
+		return instance.MiniMap
+	case "minimapsize":
+		return instance.MiniMapSize
+	case "rendermode":
+		return instance.RenderMode
+	case "textstyle":
+		return instance.TextStyle
+	case "textglyphs":
+		return instance.TextGlyphs
+	case "renderer":
+		return instance.Renderer
+	case "snakestyle":
+		return instance.SnakeStyle
+	case "spritesheet":
+		return instance.SpriteSheet
+	case "avatarhead":
+		return instance.AvatarHead
+	case "theme":
+		return instance.Theme
+	case "edges":
+		return instance.Edges
+	case "level":
+		return instance.Level
+	case "admins":
+		return instance.Admins
+	case "admintoken":
+		return instance.AdminToken
 	default:
 		return ""
 	}
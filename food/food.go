@@ -0,0 +1,287 @@
+package food
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultName 没有写入清单的普通食物，地图创建时放置的食物
+const DefaultName = "food"
+
+// RandomName 按稀有度随机选择食物时使用的名称，不能用作食物的名称
+const RandomName = "random"
+
+// 食物的效果，吃掉后作用在蛇上
+const (
+	EffectNone    = ""
+	EffectSpeedUp = "speed_up" // 加速，持续期间每次刷新前进两格
+	EffectShrink  = "shrink"   // 立即缩短到一半，至少保留蛇头
+	EffectShield  = "shield"   // 护盾，持续期间免于撞击淘汰
+	EffectPoison  = "poison"   // 中毒，持续期间每次刷新失去尾部一节
+)
+
+// defaultDuration 带持续时间的效果没有设置duration时持续的刷新次数
+const defaultDuration = 5
+
+// manifestNames 食物目录中清单文件的名称，按顺序查找
+var manifestNames = []string{"foods.json", "foods.yaml", "foods.yml"}
+
+// Food 一种食物，载入后只读
+type Food struct {
+	Name     string
+	Image    string // foods目录中的PNG图片
+	Growth   int    // 吃掉后增加的节数，负数时减少，蛇至少保留蛇头
+	Score    int    // 吃掉后获得的分数
+	Rarity   int    // 随机刷新时的稀有度，n表示出现的机会是稀有度1的食物的1/n，0表示不会随机出现
+	Effect   string // 效果，见EffectSpeedUp等
+	Duration int    // 效果持续的刷新次数，只用于加速、护盾和中毒
+	Admin    bool   // 只有管理员可以放置
+}
+
+// Avatar 食物在地图上使用的缩小后的图片
+func (f *Food) Avatar() string {
+	return strings.TrimSuffix(f.Image, filepath.Ext(f.Image)) + "_small.png"
+}
+
+// Blur 食物被吃掉后在蛇身上使用的模糊图片
+func (f *Food) Blur() string {
+	return strings.TrimSuffix(f.Image, filepath.Ext(f.Image)) + "_blur.png"
+}
+
+// Position 在(x, y)放置这种食物，记录放置时的效果，之后修改清单不影响这个食物
+func (f *Food) Position(x, y int) structs.Position {
+	return structs.Position{
+		X:      x,
+		Y:      y,
+		Avatar: f.Avatar(),
+		Food:   f.Name,
+		Effect: &structs.FoodEffect{Growth: f.Growth, Score: f.Score, Effect: f.Effect, Duration: f.Duration},
+	}
+}
+
+// plain 没有写入清单的食物，与加入清单之前的效果相同
+func plain(name string) *Food {
+	return &Food{Name: name, Image: name + ".png", Growth: 1, Score: 1, Rarity: 1}
+}
+
+var (
+	foodsMutex sync.RWMutex
+	foods      = map[string]*Food{DefaultName: plain(DefaultName)}
+)
+
+// Get 按名称查找清单中的食物
+func Get(name string) (*Food, bool) {
+	foodsMutex.RLock()
+	defer foodsMutex.RUnlock()
+	f, ok := foods[name]
+	return f, ok
+}
+
+// Lookup 按名称查找食物，清单中没有时返回普通食物，图片为<name>.png
+func Lookup(name string) *Food {
+	if f, ok := Get(name); ok {
+		return f
+	}
+	return plain(name)
+}
+
+// Names 返回清单中的食物名称
+func Names() []string {
+	foodsMutex.RLock()
+	defer foodsMutex.RUnlock()
+	names := make([]string, 0, len(foods))
+	for name := range foods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Random 按稀有度随机选择一种食物，不会选中稀有度为0或只有管理员可以放置的食物
+func Random(rng *rand.Rand) *Food {
+	foodsMutex.RLock()
+	defer foodsMutex.RUnlock()
+	names := make([]string, 0, len(foods))
+	for name := range foods {
+		names = append(names, name)
+	}
+	// 按名称排列，相同的种子选出相同的食物
+	sort.Strings(names)
+
+	var candidates []*Food
+	total := 0.0
+	for _, name := range names {
+		f := foods[name]
+		if f.Rarity > 0 && !f.Admin {
+			candidates = append(candidates, f)
+			total += 1 / float64(f.Rarity)
+		}
+	}
+	if len(candidates) == 0 {
+		return plain(DefaultName)
+	}
+	pick := rng.Float64() * total
+	for _, f := range candidates {
+		pick -= 1 / float64(f.Rarity)
+		if pick < 0 {
+			return f
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// isManifest 文件是否是食物清单，载入食物图片时跳过
+func isManifest(path string) bool {
+	base := filepath.Base(path)
+	for _, name := range manifestNames {
+		if base == name {
+			return true
+		}
+	}
+	return false
+}
+
+// entry 清单中的一种食物，未设置的项使用普通食物的值
+type entry struct {
+	Name     string `json:"name" yaml:"name"`
+	Image    string `json:"image" yaml:"image"`
+	Growth   *int   `json:"growth" yaml:"growth"`
+	Score    *int   `json:"score" yaml:"score"`
+	Rarity   *int   `json:"rarity" yaml:"rarity"`
+	Effect   string `json:"effect" yaml:"effect"`
+	Duration int    `json:"duration" yaml:"duration"`
+	Admin    bool   `json:"admin" yaml:"admin"`
+}
+
+// LoadManifest 载入食物目录中的清单，没有清单时只有普通食物
+func LoadManifest(directory string) error {
+	loaded, err := readManifest(directory)
+	if err != nil {
+		return err
+	}
+	foodsMutex.Lock()
+	foods = loaded
+	foodsMutex.Unlock()
+	return nil
+}
+
+func readManifest(directory string) (map[string]*Food, error) {
+	loaded := map[string]*Food{DefaultName: plain(DefaultName)}
+	for _, name := range manifestNames {
+		data, err := os.ReadFile(filepath.Join(directory, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var entries []entry
+		if filepath.Ext(name) == ".json" {
+			err = json.Unmarshal(data, &entries)
+		} else {
+			err = yaml.Unmarshal(data, &entries)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		seen := make(map[string]bool)
+		for _, e := range entries {
+			f, err := e.food()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			if seen[f.Name] {
+				return nil, fmt.Errorf("%s: food %q is defined twice", name, f.Name)
+			}
+			seen[f.Name] = true
+			loaded[f.Name] = f
+		}
+		break
+	}
+	return loaded, nil
+}
+
+// food 检查清单中的一项并补全默认值
+func (e entry) food() (*Food, error) {
+	if e.Name == "" || e.Name == RandomName || strings.ContainsAny(e.Name, `/\`) || strings.Contains(e.Name, "_small") || strings.Contains(e.Name, "_blur") {
+		return nil, fmt.Errorf("invalid food name %q", e.Name)
+	}
+	f := plain(e.Name)
+	if e.Image != "" {
+		if filepath.Ext(e.Image) != ".png" || filepath.Base(e.Image) != e.Image {
+			return nil, fmt.Errorf("food %q: image must be a .png file in the foods directory", e.Name)
+		}
+		f.Image = e.Image
+	}
+	if e.Growth != nil {
+		f.Growth = *e.Growth
+	}
+	if e.Score != nil {
+		f.Score = *e.Score
+	}
+	if e.Rarity != nil {
+		if *e.Rarity < 0 {
+			return nil, fmt.Errorf("food %q: invalid rarity %d", e.Name, *e.Rarity)
+		}
+		f.Rarity = *e.Rarity
+	}
+	switch e.Effect {
+	case EffectNone, EffectShrink:
+	case EffectSpeedUp, EffectShield, EffectPoison:
+		f.Duration = defaultDuration
+	default:
+		return nil, fmt.Errorf("food %q: unsupported effect %q, expected speed_up, shrink, shield or poison", e.Name, e.Effect)
+	}
+	f.Effect = e.Effect
+	if e.Duration < 0 {
+		return nil, fmt.Errorf("food %q: invalid duration %d", e.Name, e.Duration)
+	}
+	if e.Duration > 0 && f.Duration > 0 {
+		f.Duration = e.Duration
+	}
+	f.Admin = e.Admin
+	return f, nil
+}
+
+// WatchManifest 热更新食物清单，清单无效时记录日志并保留之前的版本
+func WatchManifest(directory string) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		panic(err)
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(directory); err != nil {
+		panic(err)
+	}
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if !isManifest(event.Name) {
+				continue
+			}
+			if err := LoadManifest(directory); err != nil {
+				log.Printf("Failed to reload food manifest, keeping the previous version: %v", err)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			fmt.Println("error:", err)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+// Package foodtest 提供测试中使用的食物清单
+package foodtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hoshinonyaruko/snake-in-im/food"
+)
+
+// UseManifest 在测试期间使用指定的食物清单，测试结束后恢复为只有普通食物
+func UseManifest(t testing.TB, manifest string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "foods.json"), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := food.LoadManifest(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { food.LoadManifest(t.TempDir()) })
+}
@@ -0,0 +1,327 @@
+package level
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hoshinonyaruko/snake-in-im/food"
+	"github.com/hoshinonyaruko/snake-in-im/snake"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// maxSize 关卡的最大宽度和高度
+const maxSize = 200
+
+// 文字关卡中每个字符的含义，空格与.相同
+const (
+	glyphEmpty    = '.'
+	glyphWall     = '#'
+	glyphObstacle = 'O'
+	glyphSpawn    = 'S'
+	glyphFood     = 'F'
+	glyphComment  = ";" // 以;开头的行是注释
+)
+
+//go:embed builtin/*.txt
+var builtinFiles embed.FS
+
+// Level 一张关卡地图，载入后只读
+type Level struct {
+	Name   string
+	Width  int
+	Height int
+	Edges  string             // 边缘模式，为空时使用请求或配置
+	Tiles  []structs.Tile     // 墙、障碍物和出生区域
+	Food   []structs.Position // 初始的食物
+}
+
+var (
+	levelsMutex sync.RWMutex
+	levels      = make(map[string]*Level)
+)
+
+// Get 按名称查找关卡
+func Get(name string) (*Level, bool) {
+	levelsMutex.RLock()
+	defer levelsMutex.RUnlock()
+	l, ok := levels[name]
+	return l, ok
+}
+
+// Names 返回可用的关卡名称
+func Names() []string {
+	levelsMutex.RLock()
+	defer levelsMutex.RUnlock()
+	names := make([]string, 0, len(levels))
+	for name := range levels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadLevels 载入内置关卡和目录中的关卡，关卡名为去掉扩展名的文件名，目录中的关卡可以替换同名的内置关卡
+// 支持.txt（文字网格）和.json两种格式，无效的关卡记录日志后跳过
+func LoadLevels(directory string) error {
+	loaded := make(map[string]*Level)
+	builtins, err := builtinFiles.ReadDir("builtin")
+	if err != nil {
+		return err
+	}
+	for _, entry := range builtins {
+		data, err := builtinFiles.ReadFile("builtin/" + entry.Name())
+		if err != nil {
+			return err
+		}
+		l, err := Parse(entry.Name(), data)
+		if err != nil {
+			return fmt.Errorf("built-in level %s: %v", entry.Name(), err)
+		}
+		loaded[l.Name] = l
+	}
+
+	entries, err := os.ReadDir(directory)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".txt" && ext != ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(directory, entry.Name()))
+		if err != nil {
+			log.Printf("Failed to load level %s: %v", entry.Name(), err)
+			continue
+		}
+		l, err := Parse(entry.Name(), data)
+		if err != nil {
+			log.Printf("Failed to load level %s: %v", entry.Name(), err)
+			continue
+		}
+		loaded[l.Name] = l
+	}
+
+	levelsMutex.Lock()
+	levels = loaded
+	levelsMutex.Unlock()
+	return nil
+}
+
+// Parse 按文件扩展名解析并检查关卡
+func Parse(filename string, data []byte) (*Level, error) {
+	name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	var l *Level
+	var err error
+	switch filepath.Ext(filename) {
+	case ".txt":
+		l, err = parseText(data)
+	case ".json":
+		l, err = parseJSON(data)
+	default:
+		return nil, fmt.Errorf("unsupported level format %q", filepath.Ext(filename))
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.Name = name
+	if err := l.validate(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// parseText 解析文字网格，每个字符是一个格子：
+// # 墙，O 障碍物，S 出生区域，F 食物，. 或空格为空地，以;开头的行是注释
+func parseText(data []byte) (*Level, error) {
+	// 跳过注释和首尾的空行
+	var rows [][]rune
+	var lineNumbers []int
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(line, glyphComment) || (len(rows) == 0 && strings.TrimSpace(line) == "") {
+			continue
+		}
+		rows = append(rows, []rune(line))
+		lineNumbers = append(lineNumbers, lineNumber)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for len(rows) > 0 && strings.TrimSpace(string(rows[len(rows)-1])) == "" {
+		rows = rows[:len(rows)-1]
+	}
+
+	l := &Level{Height: len(rows)}
+	for _, row := range rows {
+		if len(row) > l.Width {
+			l.Width = len(row)
+		}
+	}
+	// 行尾的空格可能被编辑器删掉，较短的行视为用空地补齐
+	for y, row := range rows {
+		for x, r := range row {
+			switch r {
+			case glyphEmpty, ' ':
+			case glyphWall:
+				l.Tiles = append(l.Tiles, structs.Tile{X: x, Y: y, Kind: structs.TileWall})
+			case glyphObstacle:
+				l.Tiles = append(l.Tiles, structs.Tile{X: x, Y: y, Kind: structs.TileObstacle})
+			case glyphSpawn:
+				l.Tiles = append(l.Tiles, structs.Tile{X: x, Y: y, Kind: structs.TileSpawn})
+			case glyphFood:
+				l.Food = append(l.Food, foodAt(food.DefaultName, x, y))
+			default:
+				return nil, fmt.Errorf("line %d: unknown cell %q", lineNumbers[y], r)
+			}
+		}
+	}
+	return l, nil
+}
+
+// rect JSON关卡中的一块矩形区域，w和h为0时为1
+type rect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// jsonLevel JSON关卡，墙、障碍物和出生区域都用矩形描述
+type jsonLevel struct {
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Edges     string `json:"edges"`
+	Walls     []rect `json:"walls"`
+	Obstacles []rect `json:"obstacles"`
+	Spawns    []rect `json:"spawns"`
+	Food      []struct {
+		X    int    `json:"x"`
+		Y    int    `json:"y"`
+		Name string `json:"name"` // foods目录中的食物名称，默认为food
+	} `json:"food"`
+}
+
+func parseJSON(data []byte) (*Level, error) {
+	var j jsonLevel
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&j); err != nil {
+		return nil, err
+	}
+	l := &Level{Width: j.Width, Height: j.Height, Edges: j.Edges}
+	add := func(rects []rect, kind string) {
+		for _, r := range rects {
+			w, h := r.W, r.H
+			if w == 0 {
+				w = 1
+			}
+			if h == 0 {
+				h = 1
+			}
+			for y := r.Y; y < r.Y+h; y++ {
+				for x := r.X; x < r.X+w; x++ {
+					l.Tiles = append(l.Tiles, structs.Tile{X: x, Y: y, Kind: kind})
+				}
+			}
+		}
+	}
+	add(j.Walls, structs.TileWall)
+	add(j.Obstacles, structs.TileObstacle)
+	add(j.Spawns, structs.TileSpawn)
+	for _, f := range j.Food {
+		name := f.Name
+		if name == "" {
+			name = food.DefaultName
+		}
+		l.Food = append(l.Food, foodAt(name, f.X, f.Y))
+	}
+	return l, nil
+}
+
+// foodAt 关卡中的一个食物，图片由食物清单决定
+func foodAt(name string, x, y int) structs.Position {
+	return structs.Position{X: x, Y: y, Avatar: food.Lookup(name).Avatar(), Food: name}
+}
+
+// validate 检查关卡：大小有效，格子和食物都在地图内且不重叠，至少有一个可以出生的格子
+func (l *Level) validate() error {
+	if l.Width <= 0 || l.Height <= 0 || l.Width > maxSize || l.Height > maxSize {
+		return fmt.Errorf("invalid size %dx%d, expected 1-%d", l.Width, l.Height, maxSize)
+	}
+	if l.Edges != "" {
+		if _, err := snake.ParseEdgeMode(l.Edges); err != nil {
+			return err
+		}
+	}
+	type cell struct{ X, Y int }
+	kinds := make(map[cell]string)
+	for _, tile := range l.Tiles {
+		c := cell{tile.X, tile.Y}
+		if tile.X < 0 || tile.X >= l.Width || tile.Y < 0 || tile.Y >= l.Height {
+			return fmt.Errorf("%s at (%d, %d) is outside the %dx%d board", tile.Kind, tile.X, tile.Y, l.Width, l.Height)
+		}
+		if kind, ok := kinds[c]; ok && kind != tile.Kind {
+			return fmt.Errorf("(%d, %d) is both %s and %s", tile.X, tile.Y, kind, tile.Kind)
+		}
+		kinds[c] = tile.Kind
+	}
+	foods := make(map[cell]bool)
+	for _, pos := range l.Food {
+		c := cell{pos.X, pos.Y}
+		if pos.X < 0 || pos.X >= l.Width || pos.Y < 0 || pos.Y >= l.Height {
+			return fmt.Errorf("food at (%d, %d) is outside the %dx%d board", pos.X, pos.Y, l.Width, l.Height)
+		}
+		if kinds[c] == structs.TileWall || kinds[c] == structs.TileObstacle {
+			return fmt.Errorf("food at (%d, %d) is inside a %s", pos.X, pos.Y, kinds[c])
+		}
+		if foods[c] {
+			return fmt.Errorf("food at (%d, %d) is defined twice", pos.X, pos.Y)
+		}
+		// 关卡中的食物必须写在食物清单中，并且不能是只有管理员可以放置的食物
+		f, ok := food.Get(pos.Food)
+		if !ok {
+			return fmt.Errorf("food at (%d, %d): unknown food %q", pos.X, pos.Y, pos.Food)
+		}
+		if f.Admin {
+			return fmt.Errorf("food at (%d, %d): food %q can only be placed by admins", pos.X, pos.Y, pos.Food)
+		}
+		foods[c] = true
+	}
+	blocked := 0
+	for _, kind := range kinds {
+		if kind == structs.TileWall || kind == structs.TileObstacle {
+			blocked++
+		}
+	}
+	if blocked+len(foods) >= l.Width*l.Height {
+		return fmt.Errorf("level has no free cell for snakes to spawn")
+	}
+	return nil
+}
+
+// Apply 把关卡复制到新的地图上，设置地图大小、格子和初始食物
+// 关卡指定了边缘模式时替换地图的边缘模式
+func (l *Level) Apply(gameMap *structs.GameMap) {
+	gameMap.Width = l.Width
+	gameMap.Height = l.Height
+	if l.Edges != "" {
+		gameMap.Edges = l.Edges
+	}
+	gameMap.Tiles = append([]structs.Tile(nil), l.Tiles...)
+	gameMap.Food = make([]structs.Position, 0, len(l.Food))
+	for _, pos := range l.Food {
+		// 食物的效果由创建地图时的食物清单决定
+		gameMap.Food = append(gameMap.Food, food.Lookup(pos.Food).Position(pos.X, pos.Y))
+	}
+}
@@ -0,0 +1,157 @@
+package level
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hoshinonyaruko/snake-in-im/food/foodtest"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+func TestParse(t *testing.T) {
+	foodtest.UseManifest(t, `[{"name": "pepper", "effect": "speed_up"}, {"name": "golden_apple", "admin": true}]`)
+
+	tests := []struct {
+		name     string
+		filename string
+		data     string
+		err      string // 为空时应当解析成功
+	}{
+		{"text", "room.txt", "; comment\n\n#####\n#S.F#\n#.O.#\n#####\n\n", ""},
+		{"text with short rows", "short.txt", "S..\n.\n..F", ""},
+		{"text unknown cell", "bad.txt", "S.\n.X", `line 2: unknown cell 'X'`},
+		{"text empty", "empty.txt", "; only a comment\n\n", "invalid size 0x0"},
+		{"text too large", "large.txt", strings.Repeat(".", 201), "invalid size 201x1"},
+		{"text no free cell", "full.txt", "##\n#F", "no free cell"},
+		{"json", "room.json", `{"width": 8, "height": 6, "edges": "solid", "walls": [{"x": 0, "y": 0, "w": 8}], "spawns": [{"x": 1, "y": 1, "w": 2, "h": 2}], "food": [{"x": 5, "y": 4}, {"x": 6, "y": 4, "name": "pepper"}]}`, ""},
+		{"json syntax", "broken.json", `{"width": 8,`, "unexpected EOF"},
+		{"json unknown field", "field.json", `{"width": 8, "height": 8, "lava": []}`, `unknown field "lava"`},
+		{"json bad edges", "edges.json", `{"width": 8, "height": 8, "edges": "spiral"}`, "unsupported edge mode"},
+		{"json wall out of range", "wall.json", `{"width": 4, "height": 4, "walls": [{"x": 3, "y": 0, "w": 2}]}`, "wall at (4, 0) is outside the 4x4 board"},
+		{"json negative obstacle", "neg.json", `{"width": 4, "height": 4, "obstacles": [{"x": -1, "y": 2}]}`, "obstacle at (-1, 2) is outside"},
+		{"json food out of range", "food.json", `{"width": 4, "height": 4, "food": [{"x": 4, "y": 4}]}`, "food at (4, 4) is outside"},
+		{"json food on wall", "foodwall.json", `{"width": 4, "height": 4, "walls": [{"x": 1, "y": 1}], "food": [{"x": 1, "y": 1}]}`, "food at (1, 1) is inside a wall"},
+		{"json food on obstacle", "foodobstacle.json", `{"width": 4, "height": 4, "obstacles": [{"x": 2, "y": 2}], "food": [{"x": 2, "y": 2}]}`, "inside a obstacle"},
+		{"json duplicate food", "twice.json", `{"width": 4, "height": 4, "food": [{"x": 1, "y": 1}, {"x": 1, "y": 1}]}`, "defined twice"},
+		{"json wall and spawn", "conflict.json", `{"width": 4, "height": 4, "walls": [{"x": 1, "y": 1}], "spawns": [{"x": 1, "y": 1}]}`, "is both wall and spawn"},
+		{"json unknown food", "unknown.json", `{"width": 4, "height": 4, "food": [{"x": 1, "y": 1, "name": "apple"}]}`, `unknown food "apple"`},
+		{"json admin food", "admin.json", `{"width": 4, "height": 4, "food": [{"x": 1, "y": 1, "name": "golden_apple"}]}`, "can only be placed by admins"},
+		{"unsupported format", "room.yaml", "width: 4", "unsupported level format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := Parse(tt.filename, []byte(tt.data))
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if want := strings.TrimSuffix(tt.filename, filepath.Ext(tt.filename)); l.Name != want {
+					t.Errorf("name %q, want %q", l.Name, want)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseFood(t *testing.T) {
+	foodtest.UseManifest(t, `[{"name": "pepper", "image": "chili.png", "effect": "speed_up"}]`)
+
+	l, err := Parse("food.json", []byte(`{"width": 5, "height": 5, "food": [{"x": 1, "y": 2}, {"x": 3, "y": 4, "name": "pepper"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []structs.Position{
+		{X: 1, Y: 2, Avatar: "food_small.png", Food: "food"},
+		{X: 3, Y: 4, Avatar: "chili_small.png", Food: "pepper"},
+	}
+	if len(l.Food) != len(want) {
+		t.Fatalf("food %v, want %v", l.Food, want)
+	}
+	for i := range want {
+		if l.Food[i] != want[i] {
+			t.Errorf("food %d is %+v, want %+v", i, l.Food[i], want[i])
+		}
+	}
+
+	l, err = Parse("text.txt", []byte("S.F\n..."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Food) != 1 || l.Food[0] != (structs.Position{X: 2, Y: 0, Avatar: "food_small.png", Food: "food"}) {
+		t.Errorf("text food %v", l.Food)
+	}
+}
+
+func TestBuiltinLevels(t *testing.T) {
+	if err := LoadLevels(filepath.Join(t.TempDir(), "missing")); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		width, height int
+	}{
+		{"box", 0, 0},
+		{"cross", 21, 17},
+		{"rooms", 0, 0},
+	}
+	for _, tt := range tests {
+		l, ok := Get(tt.name)
+		if !ok {
+			t.Errorf("built-in level %s is missing", tt.name)
+			continue
+		}
+		if tt.width > 0 && (l.Width != tt.width || l.Height != tt.height) {
+			t.Errorf("%s is %dx%d, want %dx%d", tt.name, l.Width, l.Height, tt.width, tt.height)
+		}
+		var spawns int
+		for _, tile := range l.Tiles {
+			if tile.Kind == structs.TileSpawn {
+				spawns++
+			}
+		}
+		if spawns == 0 {
+			t.Errorf("%s has no spawn area", tt.name)
+		}
+	}
+
+	// 目录中的关卡替换同名的内置关卡，无效的关卡被跳过
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "box.txt"), []byte("S.\n.."), 0644)
+	os.WriteFile(filepath.Join(dir, "broken.txt"), []byte("S?"), 0644)
+	if err := LoadLevels(dir); err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := Get("box"); l == nil || l.Width != 2 {
+		t.Errorf("box was not replaced: %+v", l)
+	}
+	if _, ok := Get("broken"); ok {
+		t.Error("invalid level was loaded")
+	}
+}
+
+func TestApply(t *testing.T) {
+	l, err := Parse("small.txt", []byte("#S\nF."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameMap := &structs.GameMap{Width: 30, Height: 30, Edges: "wrap"}
+	l.Apply(gameMap)
+	if gameMap.Width != 2 || gameMap.Height != 2 || len(gameMap.Tiles) != 2 || len(gameMap.Food) != 1 {
+		t.Fatalf("applied map %+v", gameMap)
+	}
+	if effect := gameMap.Food[0].Effect; effect == nil || effect.Growth != 1 || effect.Score != 1 {
+		t.Errorf("applied food has effect %+v, want plain food", effect)
+	}
+	// 修改地图不影响关卡
+	gameMap.Food[0].X = 1
+	gameMap.Tiles[0].Kind = structs.TileObstacle
+	if l.Food[0].X != 0 || l.Tiles[0].Kind != structs.TileWall {
+		t.Error("applied map shares memory with the level")
+	}
+}
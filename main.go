@@ -7,39 +7,78 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/hoshinonyaruko/snake-in-im/api"
 	"github.com/hoshinonyaruko/snake-in-im/config"
+	"github.com/hoshinonyaruko/snake-in-im/food"
+	"github.com/hoshinonyaruko/snake-in-im/level"
 	"github.com/hoshinonyaruko/snake-in-im/memimg"
+	"github.com/hoshinonyaruko/snake-in-im/theme"
 )
 
 func main() {
-	EnsureFoldersExist()
 	// Initialize the configuration
 	config.LoadConfig("./config.json")
+	// 管理员的请求必须携带口令，没有口令时管理员不生效
+	if len(config.GetConfigValue("admins").([]string)) > 0 && config.GetConfigValue("admintoken").(string) == "" {
+		log.Printf("Warning: admins are configured but admintoken is empty, admin requests will be refused")
+	}
+	EnsureFoldersExist()
 	// 载入头像到内存
 	memimg.LoadAvatars("./avatar")
 	// 加载食物图标
 	memimg.LoadFoods("./foods")
+	// 加载食物清单，没有清单时只有普通食物
+	if err := food.LoadManifest("./foods"); err != nil {
+		log.Printf("Failed to load food manifest: %v", err)
+	}
+	// 加载背景图片
+	memimg.LoadBackgrounds("./backgrounds")
 	// 获取blockSize
 	blockSize := config.GetConfigValue("blocksize").(int)
 	// 预处理
 	api.PreloadAndScaleFoods("./foods", blockSize)
+	// 加载蛇的精灵图，没有时使用内置的精灵图
+	api.LoadSprites(config.GetConfigValue("spritesheet").(string), blockSize)
+	// 加载主题
+	if err := theme.LoadThemes("./themes"); err != nil {
+		log.Printf("Failed to load themes: %v", err)
+	}
+	// 加载关卡，包括内置关卡
+	if err := level.LoadLevels("./levels"); err != nil {
+		log.Printf("Failed to load levels: %v", err)
+	}
 	// 检测并热更新到内存 加速绘图
 	go memimg.WatchFoods("./foods")
-	db := api.InitDB()
+	go food.WatchManifest("./foods")
+	go memimg.WatchBackgrounds("./backgrounds", api.InvalidateImageBackground)
+	go theme.WatchThemes("./themes", api.InvalidateTheme)
+	// 游戏存储 sqlite或memory
+	repo := api.InitRepository(config.GetConfigValue("storage").(string))
 	router := gin.Default()
 	// 处理玩家改变方向
-	router.GET("/update-direction", api.UpdateDirection(db))
+	router.GET("/update-direction", api.UpdateDirection(repo))
 	// 渲染函数 返回静态地址
-	router.GET("/render-map", api.RenderMapHandler(db))
+	router.GET("/render-map", api.RenderMapHandler(repo))
 	// 删除地图
-	router.GET("/delete-map", api.DeleteMapHandler(db))
+	router.GET("/delete-map", api.DeleteMapHandler(repo))
+	// 管理员放置食物
+	router.GET("/spawn-food", api.SpawnFoodHandler(repo))
+	// 以JSON返回完整的游戏状态
+	router.GET("/state", api.StateHandler(repo))
+	// 淘汰事件
+	router.GET("/deaths", api.DeathsHandler(repo))
 	router.Static("/static", "./static") // 静态文件服务
+	// 内存中的渲染结果
+	router.GET("/renders/:group/:name", api.RendersHandler())
 	// 从配置单例读取端口 监听
 	router.Run(":" + config.GetConfigValue("port").(string))
 }
 
 // EnsureFoldersExists 检查并创建必需的文件夹
 func EnsureFoldersExist() {
-	folders := []string{"foods", "static", "avatar"}
+	folders := []string{"foods", "avatar", "backgrounds", "themes", "levels"}
+	// 渲染结果只保存在内存中时不需要static目录，方便在只读容器中运行
+	if config.GetConfigValue("renderstore").(string) != "memory" {
+		folders = append(folders, "static")
+	}
 
 	for _, folder := range folders {
 		if _, err := os.Stat(folder); os.IsNotExist(err) {
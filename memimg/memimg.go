@@ -5,6 +5,7 @@ import (
 	"image"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/fsnotify/fsnotify"
@@ -15,6 +16,9 @@ var (
 	AvatarsMutex sync.RWMutex
 	foods        map[string]image.Image
 	foodsMutex   sync.RWMutex
+	// 背景图片，文件名去掉扩展名作为名称
+	backgrounds      map[string]image.Image
+	backgroundsMutex sync.RWMutex
 )
 
 func LoadAvatars(directory string) error {
@@ -60,6 +64,10 @@ func LoadFoods(directory string) error {
 		if err != nil {
 			return err
 		}
+		// 食物清单与图片放在同一目录，由food包载入
+		if ext := filepath.Ext(path); ext == ".json" || ext == ".yaml" || ext == ".yml" {
+			return nil
+		}
 		if !info.IsDir() {
 			img, err := LoadImage(path)
 			if err != nil {
@@ -72,6 +80,15 @@ func LoadFoods(directory string) error {
 }
 
 func WatchFoods(directory string) {
+	watchDirectory(directory, func(path string, img image.Image) {
+		foodsMutex.Lock()
+		foods[filepath.Base(path)] = img
+		foodsMutex.Unlock()
+	})
+}
+
+// watchDirectory 监视目录，文件被创建或修改时重新载入图片并交给store
+func watchDirectory(directory string, store func(path string, img image.Image)) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		panic(err)
@@ -89,9 +106,7 @@ func WatchFoods(directory string) {
 				if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
 					img, err := LoadImage(event.Name)
 					if err == nil {
-						foodsMutex.Lock()
-						foods[filepath.Base(event.Name)] = img
-						foodsMutex.Unlock()
+						store(event.Name, img)
 					}
 				}
 			case err, ok := <-watcher.Errors:
@@ -116,3 +131,50 @@ func GetFoodFromMemory(filename string) (image.Image, bool) {
 	foodsMutex.RUnlock()
 	return img, exists
 }
+
+// backgroundName 背景图片的名称为去掉扩展名的文件名
+func backgroundName(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+func LoadBackgrounds(directory string) error {
+	backgroundsMutex.Lock()
+	backgrounds = make(map[string]image.Image)
+	backgroundsMutex.Unlock()
+	return filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			img, err := LoadImage(path)
+			if err != nil {
+				return err
+			}
+			backgroundsMutex.Lock()
+			backgrounds[backgroundName(path)] = img
+			backgroundsMutex.Unlock()
+		}
+		return nil
+	})
+}
+
+// WatchBackgrounds 热更新背景图片，onChange在图片更新后以背景名称调用
+func WatchBackgrounds(directory string, onChange func(name string)) {
+	watchDirectory(directory, func(path string, img image.Image) {
+		name := backgroundName(path)
+		backgroundsMutex.Lock()
+		backgrounds[name] = img
+		backgroundsMutex.Unlock()
+		if onChange != nil {
+			onChange(name)
+		}
+	})
+}
+
+func GetBackgroundFromMemory(name string) (image.Image, bool) {
+	backgroundsMutex.RLock()
+	img, exists := backgrounds[name]
+	backgroundsMutex.RUnlock()
+	return img, exists
+}
@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// Memory 纯内存的游戏存储，用于测试和不需要持久化的部署
+type Memory struct {
+	mu     sync.RWMutex
+	games  map[string]*structs.Game
+	deaths map[string][]structs.Death
+	inputs map[string][]structs.Input
+}
+
+// NewMemory 创建一个空的内存存储
+func NewMemory() *Memory {
+	return &Memory{
+		games:  make(map[string]*structs.Game),
+		deaths: make(map[string][]structs.Death),
+		inputs: make(map[string][]structs.Input),
+	}
+}
+
+// cloneGame 深拷贝游戏，调用者的修改只有在Save之后才会生效
+func cloneGame(game *structs.Game) *structs.Game {
+	clone := *game
+	clone.Map.Snakes = make(map[string]structs.Snake, len(game.Map.Snakes))
+	for id, snake := range game.Map.Snakes {
+		snake.Positions = append([]structs.Position(nil), snake.Positions...)
+		clone.Map.Snakes[id] = snake
+	}
+	clone.Map.Food = append([]structs.Position(nil), game.Map.Food...)
+	clone.Map.Tiles = append([]structs.Tile(nil), game.Map.Tiles...)
+	clone.Inputs = nil
+	clone.Nicknames = make(map[string]string, len(game.Nicknames))
+	for openID, nickname := range game.Nicknames {
+		clone.Nicknames[openID] = nickname
+	}
+	return &clone
+}
+
+func (m *Memory) Load(groupID string) (*structs.Game, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	game, ok := m.games[groupID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return cloneGame(game), nil
+}
+
+func (m *Memory) Create(game *structs.Game) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.games[game.GroupID] = cloneGame(game)
+	m.inputs[game.GroupID] = append([]structs.Input(nil), game.Inputs...)
+	game.Inputs = nil
+	return nil
+}
+
+func (m *Memory) Save(game *structs.Game, deaths []structs.Death) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.games[game.GroupID] = cloneGame(game)
+	m.deaths[game.GroupID] = append(m.deaths[game.GroupID], deaths...)
+	m.inputs[game.GroupID] = append(m.inputs[game.GroupID], game.Inputs...)
+	game.Inputs = nil
+	return nil
+}
+
+func (m *Memory) Delete(groupID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.games, groupID)
+	delete(m.deaths, groupID)
+	delete(m.inputs, groupID)
+	return nil
+}
+
+func (m *Memory) SetDirection(groupID, openID, direction string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	game, ok := m.games[groupID]
+	if !ok {
+		return ErrNotFound
+	}
+	snake, ok := game.Map.Snakes[openID]
+	if !ok {
+		return ErrNotFound
+	}
+	snake.Direction = direction
+	game.Map.Snakes[openID] = snake
+	m.inputs[groupID] = append(m.inputs[groupID], structs.Input{Tick: game.Tick, Kind: structs.InputDirection, OpenID: openID, Direction: direction})
+	return nil
+}
+
+func (m *Memory) List() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	groupIDs := make([]string, 0, len(m.games))
+	for groupID := range m.games {
+		groupIDs = append(groupIDs, groupID)
+	}
+	sort.Strings(groupIDs)
+	return groupIDs, nil
+}
+
+func (m *Memory) Deaths(groupID string, afterTick int64) ([]structs.Death, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	deaths := []structs.Death{}
+	for _, death := range m.deaths[groupID] {
+		if death.Tick > afterTick {
+			deaths = append(deaths, death)
+		}
+	}
+	return deaths, nil
+}
+
+func (m *Memory) Inputs(groupID string) ([]structs.Input, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]structs.Input{}, m.inputs[groupID]...), nil
+}
@@ -0,0 +1,31 @@
+// 游戏状态的存储接口
+package repository
+
+import (
+	"errors"
+
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// ErrNotFound 指定的游戏或蛇不存在
+var ErrNotFound = errors.New("not found")
+
+// GameRepository 描述游戏状态的存储，api只通过它读写游戏
+type GameRepository interface {
+	// Load 读取群组的游戏，不存在时返回ErrNotFound
+	Load(groupID string) (*structs.Game, error)
+	// Create 保存一个新创建的游戏和它的初始输入，成功后清空game.Inputs
+	Create(game *structs.Game) error
+	// Save 保存游戏状态，删除已经不在地图上的蛇，记录淘汰事件并追加game.Inputs中的输入，成功后清空game.Inputs
+	Save(game *structs.Game, deaths []structs.Death) error
+	// Delete 删除群组的游戏和所有相关记录
+	Delete(groupID string) error
+	// SetDirection 修改玩家的蛇的方向并记录为输入，蛇不存在时返回ErrNotFound
+	SetDirection(groupID, openID, direction string) error
+	// List 返回所有游戏的群组ID，按字典序排列
+	List() ([]string, error)
+	// Deaths 返回群组在指定刷新次数之后的淘汰事件，按发生顺序排列
+	Deaths(groupID string, afterTick int64) ([]structs.Death, error)
+	// Inputs 返回群组的输入记录，按产生顺序排列，配合种子可以回放对局
+	Inputs(groupID string) ([]structs.Input, error)
+}
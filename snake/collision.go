@@ -0,0 +1,289 @@
+// 同时移动的碰撞判定
+package snake
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// 淘汰原因
+const (
+	CauseSelf   = "self"    // 咬到自己
+	CauseHeadOn = "head_on" // 蛇头相撞且长度相同
+	CauseEaten  = "eaten"   // 被其他蛇吃掉
+)
+
+// TickResult 描述一次刷新的结果
+type TickResult struct {
+	EatenFood []structs.Position // 本次被吃掉的食物
+	Deaths    []structs.Death    // 本次被淘汰的蛇，按OpenID排序
+}
+
+type cell struct{ X, Y int }
+
+// Tick 保存一次刷新的全部碰撞记录，只属于一个游戏的一次刷新，
+// 不同群组、不同请求之间不共享任何状态
+type Tick struct {
+	gameMap  *structs.GameMap
+	number   int64
+	ids      []string
+	lengths  map[string]int
+	oldHeads map[string]cell
+	before   map[string]structs.Snake // 移动前的蛇，护盾挡住撞墙时恢复
+	selfHit  map[string]bool
+	crashed  map[string]string // 撞墙或障碍物的蛇 -> 淘汰原因
+	headOn   map[string]bool
+	eatenBy  map[string][]string // 被吃者 -> 所有吃它的蛇
+	shielded map[string]bool     // 被护盾挡住淘汰的蛇
+}
+
+// NewTick 为地图的第number次刷新创建碰撞记录
+func NewTick(gameMap *structs.GameMap, number int64) *Tick {
+	t := &Tick{
+		gameMap:  gameMap,
+		number:   number,
+		ids:      SortedSnakeIDs(gameMap.Snakes),
+		lengths:  make(map[string]int),
+		oldHeads: make(map[string]cell),
+		before:   make(map[string]structs.Snake),
+		selfHit:  make(map[string]bool),
+		crashed:  make(map[string]string),
+		headOn:   make(map[string]bool),
+		eatenBy:  make(map[string][]string),
+		shielded: make(map[string]bool),
+	}
+	for _, id := range t.ids {
+		snake := gameMap.Snakes[id]
+		t.lengths[id] = len(snake.Positions)
+		if len(snake.Positions) > 0 {
+			t.oldHeads[id] = cell{snake.Positions[0].X, snake.Positions[0].Y}
+		}
+	}
+	return t
+}
+
+// ResolveTick 让所有蛇同时前进一格并结算碰撞，结果与蛇的遍历顺序无关。
+//
+// 所有判定都基于全部蛇移动之后的同一个快照，长度取移动前的长度：
+//  0. 边缘模式为solid时撞墙的蛇停在原地并死亡（wall），蛇头落在关卡的墙（wall）或
+//     障碍物（obstacle）上的蛇死亡，这些蛇不参与之后的判定；有护盾的蛇停在移动前的位置，
+//     整条蛇（包括蛇头）都按身体参与规则3的判定。加速的蛇前进两格，经过的第一格只检查墙和障碍物。
+//  1. 蛇头落在自己身体上，该蛇死亡（self）。
+//  2. 多个蛇头落在同一格，或两条蛇互相穿过对方的头，最长的蛇吃掉其余的蛇；
+//     最长的有多条时，这一格的蛇全部死亡（head_on）。
+//  3. 蛇头落在其他蛇的身体上，两者中较长的吃掉较短的，长度相同时撞上去的一方获胜（eaten）。
+//  4. 一条蛇被多条蛇吃掉时，由其中最长的记功，长度相同时取OpenID最小的。
+//     同时记录多种原因时，优先级为 eaten > head_on > self。
+//     有护盾的蛇不会因为以上原因死亡，撞墙或障碍物时退回移动前的位置，吃它的蛇不记功。
+//  5. 移除死亡的蛇，存活的吃蛇者每吃一条蛇尾部增加一节，使用被吃者的模糊头像。
+//  6. 加速和护盾的剩余次数减一；中毒的蛇失去尾部一节，只剩蛇头时死亡（poison）。
+//  7. 存活的蛇头落在食物上则吃掉食物，按食物的种类增长、得分并获得效果。
+func ResolveTick(gameMap *structs.GameMap, number int64) TickResult {
+	return NewTick(gameMap, number).Resolve()
+}
+
+// Resolve 执行移动并按ResolveTick描述的规则结算
+func (t *Tick) Resolve() TickResult {
+	t.moveAll()
+	t.detectCollisions()
+	deaths, credited := t.settle()
+	t.apply(deaths, credited)
+	if poisoned := t.wearOff(); len(poisoned) > 0 {
+		deaths = append(deaths, poisoned...)
+		sort.SliceStable(deaths, func(i, j int) bool { return deaths[i].OpenID < deaths[j].OpenID })
+	}
+
+	// 规则7：结算食物
+	return TickResult{
+		EatenFood: CheckFoodCollisions(t.gameMap),
+		Deaths:    deaths,
+	}
+}
+
+// moveAll 所有蛇同时前进，按地图的边缘模式处理越过边缘的蛇头，并检查关卡中的墙和障碍物
+func (t *Tick) moveAll() {
+	var tiles map[cell]string
+	if len(t.gameMap.Tiles) > 0 {
+		tiles = tileIndex(t.gameMap)
+	}
+	for _, id := range t.ids {
+		snake := t.gameMap.Snakes[id]
+		t.before[id] = snake
+		steps := 1
+		if snake.SpeedUp > 0 {
+			steps = 2
+		}
+		for step := 0; step < steps && t.crashed[id] == ""; step++ {
+			var hitWall bool
+			snake, hitWall = MoveSnakeInMap(snake, t.gameMap)
+			// 规则0：撞墙或障碍物
+			if hitWall {
+				t.crashed[id] = CauseWall
+			} else if len(snake.Positions) > 0 {
+				if cause := crashCause(tiles[cell{snake.Positions[0].X, snake.Positions[0].Y}]); cause != "" {
+					t.crashed[id] = cause
+				}
+			}
+		}
+		t.gameMap.Snakes[id] = snake
+	}
+}
+
+// detectCollisions 在移动后的快照上记录所有碰撞
+func (t *Tick) detectCollisions() {
+	heads := make(map[cell][]string)
+	bodies := make(map[cell][]string)
+	for _, id := range t.ids {
+		if t.crashed[id] != "" {
+			// 有护盾的蛇撞墙后会退回移动前的位置，整条蛇都按身体参与判定，其他蛇不能从中穿过
+			if t.gameMap.Snakes[id].Shield > 0 {
+				for _, pos := range t.before[id].Positions {
+					c := cell{pos.X, pos.Y}
+					bodies[c] = append(bodies[c], id)
+				}
+			}
+			continue
+		}
+		for i, pos := range t.gameMap.Snakes[id].Positions {
+			c := cell{pos.X, pos.Y}
+			if i == 0 {
+				heads[c] = append(heads[c], id)
+			} else {
+				bodies[c] = append(bodies[c], id)
+			}
+		}
+	}
+
+	for _, id := range t.ids {
+		snake := t.gameMap.Snakes[id]
+		if len(snake.Positions) == 0 || t.crashed[id] != "" {
+			continue
+		}
+		head := cell{snake.Positions[0].X, snake.Positions[0].Y}
+
+		for _, other := range bodies[head] {
+			if other == id {
+				// 规则1：咬到自己
+				t.selfHit[id] = true
+			} else if t.swapped(id, other) {
+				// 互相穿过对方的头时，对方的旧蛇头已经成为身体，按规则2处理
+				continue
+			} else if t.lengths[id] >= t.lengths[other] {
+				// 规则3：撞到其他蛇的身体
+				t.eatenBy[other] = append(t.eatenBy[other], id)
+			} else {
+				t.eatenBy[id] = append(t.eatenBy[id], other)
+			}
+		}
+
+		// 规则2：互相穿过对方的头，每对只处理一次
+		for _, other := range t.ids {
+			if other > id && t.swapped(id, other) {
+				t.headOnGroup([]string{id, other})
+			}
+		}
+
+		// 规则2：蛇头落在同一格，由组内OpenID最小的蛇处理一次
+		if group := heads[head]; len(group) > 1 && group[0] == id {
+			t.headOnGroup(group)
+		}
+	}
+}
+
+// swapped 两条都没有撞墙的蛇互相穿过对方的头：蛇头落在对方移动前的蛇头，对方的蛇头落在自己移动前的蛇头
+func (t *Tick) swapped(id, other string) bool {
+	if t.crashed[id] != "" || t.crashed[other] != "" {
+		return false
+	}
+	snake, otherSnake := t.gameMap.Snakes[id], t.gameMap.Snakes[other]
+	if len(snake.Positions) == 0 || len(otherSnake.Positions) == 0 {
+		return false
+	}
+	return t.oldHeads[other] == (cell{snake.Positions[0].X, snake.Positions[0].Y}) &&
+		t.oldHeads[id] == (cell{otherSnake.Positions[0].X, otherSnake.Positions[0].Y})
+}
+
+// headOnGroup 处理一组蛇头相撞的蛇
+func (t *Tick) headOnGroup(group []string) {
+	longest := 0
+	for _, id := range group {
+		if t.lengths[id] > longest {
+			longest = t.lengths[id]
+		}
+	}
+	var winners []string
+	for _, id := range group {
+		if t.lengths[id] == longest {
+			winners = append(winners, id)
+		}
+	}
+	if len(winners) > 1 {
+		for _, id := range group {
+			t.headOn[id] = true
+		}
+		return
+	}
+	for _, id := range group {
+		if id != winners[0] {
+			t.eatenBy[id] = append(t.eatenBy[id], winners[0])
+		}
+	}
+}
+
+// settle 规则4：确定死亡的蛇和每条被吃掉的蛇记在谁的名下
+func (t *Tick) settle() ([]structs.Death, map[string][]string) {
+	var deaths []structs.Death
+	credited := make(map[string][]string) // 吃蛇者 -> 记在它名下的被吃者
+	for _, id := range t.ids {
+		dies := t.crashed[id] != "" || len(t.eatenBy[id]) > 0 || t.headOn[id] || t.selfHit[id]
+		if dies && t.gameMap.Snakes[id].Shield > 0 {
+			t.shielded[id] = true
+			continue
+		}
+		death := structs.Death{OpenID: id, Length: t.lengths[id], Tick: t.number}
+		switch {
+		case t.crashed[id] != "":
+			death.Cause = t.crashed[id]
+		case len(t.eatenBy[id]) > 0:
+			killer := t.eatenBy[id][0]
+			for _, eater := range t.eatenBy[id][1:] {
+				if t.lengths[eater] > t.lengths[killer] || (t.lengths[eater] == t.lengths[killer] && eater < killer) {
+					killer = eater
+				}
+			}
+			death.KillerID = killer
+			death.Cause = CauseEaten
+			credited[killer] = append(credited[killer], id)
+		case t.headOn[id]:
+			death.Cause = CauseHeadOn
+		case t.selfHit[id]:
+			death.Cause = CauseSelf
+		default:
+			continue
+		}
+		deaths = append(deaths, death)
+	}
+	return deaths, credited
+}
+
+// apply 规则5：移除死亡的蛇并让存活的吃蛇者增长，被护盾挡住撞墙的蛇退回移动前的位置
+func (t *Tick) apply(deaths []structs.Death, credited map[string][]string) {
+	for _, death := range deaths {
+		delete(t.gameMap.Snakes, death.OpenID)
+	}
+	for _, id := range t.ids {
+		snake, alive := t.gameMap.Snakes[id]
+		if !alive {
+			continue
+		}
+		if t.shielded[id] && t.crashed[id] != "" {
+			snake.Positions = t.before[id].Positions
+		}
+		for _, victim := range credited[id] {
+			GrowTail(&snake, fmt.Sprintf("%s_blur_small.jpg", victim), t.gameMap)
+		}
+		t.gameMap.Snakes[id] = snake
+	}
+}
@@ -0,0 +1,266 @@
+package snake
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// headStep 两个蛇头之间在地图上的步数，边缘相连时按最短方向计算
+func headStep(gameMap *structs.GameMap, from, to structs.Position) int {
+	dx, dy := abs(to.X-from.X), abs(to.Y-from.Y)
+	if Wraps(gameMap) {
+		dx = min(dx, gameMap.Width-dx)
+		dy = min(dy, gameMap.Height-dy)
+	}
+	return dx + dy
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// TestEachSnakeMovesOncePerTick 多个群组的游戏在不同的goroutine中同时刷新，
+// 每次刷新中每条存活的蛇只前进一格
+func TestEachSnakeMovesOncePerTick(t *testing.T) {
+	const groups, players, requests = 8, 6, 40
+	start := time.Unix(1700000000, 0)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, groups)
+	for g := 0; g < groups; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			edges := []string{EdgeWrap, EdgeSolid}[g%2]
+			clock := &stepClock{now: start}
+			engine := NewEngine(clock)
+			game := &structs.Game{
+				GroupID:         fmt.Sprintf("group-%d", g),
+				RefreshInterval: 5,
+				Seed:            int64(g + 1),
+				LastRefresh:     start.Unix(),
+				Map:             structs.GameMap{Snakes: make(map[string]structs.Snake), Width: 15, Height: 15, Edges: edges},
+			}
+
+			var previous *structs.GameMap
+			var lastTick int64
+			check := func(game *structs.Game) {
+				if previous != nil && game.Tick != lastTick {
+					if game.Tick != lastTick+1 {
+						errs <- fmt.Errorf("%s: tick jumped from %d to %d", game.GroupID, lastTick, game.Tick)
+					}
+					for id, s := range game.Map.Snakes {
+						before, ok := previous.Snakes[id]
+						if !ok {
+							continue
+						}
+						if step := headStep(&game.Map, before.Positions[0], s.Positions[0]); step != 1 {
+							errs <- fmt.Errorf("%s: snake %s moved %d cells in tick %d", game.GroupID, id, step, game.Tick)
+						}
+					}
+				}
+				clone := CloneGameMap(game.Map)
+				previous = &clone
+				lastTick = game.Tick
+			}
+
+			for i := 0; i < requests; i++ {
+				clock.now = clock.now.Add(3 * time.Second)
+				if i%5 == 0 {
+					AddFoodToGameMap(game, "food")
+				}
+				previous = nil
+				if _, err := engine.UpdateEach(game, fmt.Sprintf("player-%d", i%players), check); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// snakeAt 一条朝direction移动的蛇，cells从蛇头开始
+func snakeAt(id, direction string, cells ...[2]int) structs.Snake {
+	s := structs.Snake{OpenID: id, Direction: direction}
+	for _, c := range cells {
+		s.Positions = append(s.Positions, structs.Position{X: c[0], Y: c[1], Avatar: id + "_small.jpg"})
+	}
+	return s
+}
+
+// withShield 给蛇加上护盾
+func withShield(s structs.Snake) structs.Snake {
+	s.Shield = 2
+	return s
+}
+
+func TestResolveTick(t *testing.T) {
+	tests := []struct {
+		name    string
+		snakes  []structs.Snake
+		tiles   []structs.Tile
+		deaths  []structs.Death // 只比较OpenID、KillerID和Cause
+		lengths map[string]int  // 存活的蛇结算后的长度
+		ordered bool            // 结果依赖OpenID的大小，改名后不再成立
+	}{
+		{
+			name: "same cell, equal length",
+			snakes: []structs.Snake{
+				snakeAt("a", "right", [2]int{3, 5}, [2]int{2, 5}),
+				snakeAt("b", "left", [2]int{5, 5}, [2]int{6, 5}),
+			},
+			deaths: []structs.Death{{OpenID: "a", Cause: CauseHeadOn}, {OpenID: "b", Cause: CauseHeadOn}},
+		},
+		{
+			name: "swap, equal length",
+			snakes: []structs.Snake{
+				snakeAt("a", "right", [2]int{3, 5}, [2]int{2, 5}),
+				snakeAt("b", "left", [2]int{4, 5}, [2]int{5, 5}),
+			},
+			deaths: []structs.Death{{OpenID: "a", Cause: CauseHeadOn}, {OpenID: "b", Cause: CauseHeadOn}},
+		},
+		{
+			name: "swap, equal length 1",
+			snakes: []structs.Snake{
+				snakeAt("a", "right", [2]int{3, 5}),
+				snakeAt("b", "left", [2]int{4, 5}),
+			},
+			deaths: []structs.Death{{OpenID: "a", Cause: CauseHeadOn}, {OpenID: "b", Cause: CauseHeadOn}},
+		},
+		{
+			name: "swap, longer eats shorter",
+			snakes: []structs.Snake{
+				snakeAt("a", "right", [2]int{3, 5}, [2]int{2, 5}, [2]int{1, 5}),
+				snakeAt("b", "left", [2]int{4, 5}, [2]int{5, 5}),
+			},
+			deaths:  []structs.Death{{OpenID: "b", KillerID: "a", Cause: CauseEaten}},
+			lengths: map[string]int{"a": 4},
+		},
+		{
+			name: "shorter head hits longer body",
+			snakes: []structs.Snake{
+				snakeAt("a", "down", [2]int{4, 4}, [2]int{4, 3}),
+				snakeAt("b", "right", [2]int{5, 5}, [2]int{4, 5}, [2]int{3, 5}, [2]int{2, 5}),
+			},
+			deaths:  []structs.Death{{OpenID: "a", KillerID: "b", Cause: CauseEaten}},
+			lengths: map[string]int{"b": 5},
+		},
+		{
+			name: "longer head hits shorter body",
+			snakes: []structs.Snake{
+				snakeAt("a", "down", [2]int{5, 4}, [2]int{5, 3}, [2]int{5, 2}, [2]int{5, 1}),
+				snakeAt("b", "right", [2]int{5, 5}, [2]int{4, 5}),
+			},
+			deaths:  []structs.Death{{OpenID: "b", KillerID: "a", Cause: CauseEaten}},
+			lengths: map[string]int{"a": 5},
+		},
+		{
+			name: "equal head hits body",
+			snakes: []structs.Snake{
+				snakeAt("a", "down", [2]int{5, 4}, [2]int{5, 3}),
+				snakeAt("b", "right", [2]int{5, 5}, [2]int{4, 5}),
+			},
+			deaths:  []structs.Death{{OpenID: "b", KillerID: "a", Cause: CauseEaten}},
+			lengths: map[string]int{"a": 3},
+		},
+		{
+			name: "credit goes to the longest eater",
+			snakes: []structs.Snake{
+				snakeAt("v", "down", [2]int{5, 5}, [2]int{5, 4}, [2]int{5, 3}),
+				snakeAt("a", "right", [2]int{4, 5}, [2]int{3, 5}, [2]int{2, 5}),
+				snakeAt("c", "left", [2]int{6, 4}, [2]int{7, 4}, [2]int{8, 4}, [2]int{9, 4}),
+			},
+			deaths:  []structs.Death{{OpenID: "v", KillerID: "c", Cause: CauseEaten}},
+			lengths: map[string]int{"a": 3, "c": 5},
+		},
+		{
+			name: "equal eaters credit the smallest OpenID",
+			snakes: []structs.Snake{
+				snakeAt("v", "down", [2]int{5, 5}, [2]int{5, 4}, [2]int{5, 3}),
+				snakeAt("a", "right", [2]int{4, 5}, [2]int{3, 5}, [2]int{2, 5}),
+				snakeAt("c", "left", [2]int{6, 4}, [2]int{7, 4}, [2]int{8, 4}),
+			},
+			deaths:  []structs.Death{{OpenID: "v", KillerID: "a", Cause: CauseEaten}},
+			lengths: map[string]int{"a": 4, "c": 3},
+			ordered: true,
+		},
+		{
+			name: "shielded crash blocks a shorter snake",
+			snakes: []structs.Snake{
+				withShield(snakeAt("v", "right", [2]int{5, 5}, [2]int{4, 5}, [2]int{3, 5})),
+				snakeAt("a", "down", [2]int{5, 4}, [2]int{5, 3}),
+			},
+			tiles:   []structs.Tile{{X: 6, Y: 5, Kind: structs.TileWall}},
+			deaths:  []structs.Death{{OpenID: "a", KillerID: "v", Cause: CauseEaten}},
+			lengths: map[string]int{"v": 4},
+		},
+		{
+			name: "longer snake cannot eat a shielded crash",
+			snakes: []structs.Snake{
+				withShield(snakeAt("v", "right", [2]int{5, 5}, [2]int{4, 5})),
+				snakeAt("a", "down", [2]int{4, 4}, [2]int{4, 3}, [2]int{4, 2}),
+			},
+			tiles:   []structs.Tile{{X: 6, Y: 5, Kind: structs.TileObstacle}},
+			lengths: map[string]int{"v": 2, "a": 3},
+		},
+	}
+
+	// 改名使蛇的处理顺序反过来，结果只有名字不同
+	renames := []map[string]string{
+		{"a": "a", "b": "b", "c": "c", "v": "v"},
+		{"a": "z", "b": "y", "c": "x", "v": "w"},
+	}
+	for _, tt := range tests {
+		for _, rename := range renames {
+			if tt.ordered && rename["a"] != "a" {
+				continue
+			}
+			t.Run(tt.name+" as "+rename["a"], func(t *testing.T) {
+				gameMap := &structs.GameMap{Width: 12, Height: 12, Snakes: make(map[string]structs.Snake), Tiles: tt.tiles}
+				for _, s := range tt.snakes {
+					s.OpenID = rename[s.OpenID]
+					s.Positions = append([]structs.Position(nil), s.Positions...)
+					gameMap.Snakes[s.OpenID] = s
+				}
+				result := ResolveTick(gameMap, 1)
+
+				want := make(map[string]structs.Death)
+				for _, d := range tt.deaths {
+					d.OpenID = rename[d.OpenID]
+					if d.KillerID != "" {
+						d.KillerID = rename[d.KillerID]
+					}
+					want[d.OpenID] = d
+				}
+				if len(result.Deaths) != len(want) {
+					t.Fatalf("deaths %+v, want %+v", result.Deaths, tt.deaths)
+				}
+				for _, d := range result.Deaths {
+					if w := want[d.OpenID]; d.KillerID != w.KillerID || d.Cause != w.Cause {
+						t.Errorf("death %+v, want %+v", d, w)
+					}
+					if _, alive := gameMap.Snakes[d.OpenID]; alive {
+						t.Errorf("%s died but is still on the map", d.OpenID)
+					}
+				}
+				for id, length := range tt.lengths {
+					if s, alive := gameMap.Snakes[rename[id]]; !alive || len(s.Positions) != length {
+						t.Errorf("%s has length %d (alive %v), want %d", rename[id], len(s.Positions), alive, length)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// 地图边缘的处理方式
+package snake
+
+import (
+	"fmt"
+
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// 边缘模式，在创建游戏时选择
+const (
+	EdgeWrap   = "wrap"   // 从另一侧出现
+	EdgeSolid  = "solid"  // 撞墙淘汰
+	EdgeBounce = "bounce" // 撞墙后掉头，蛇尾变为蛇头
+)
+
+// CauseWall 撞墙淘汰，只在solid模式下出现
+const CauseWall = "wall"
+
+// ParseEdgeMode 检查边缘模式，空字符串表示wrap
+func ParseEdgeMode(mode string) (string, error) {
+	switch mode {
+	case "":
+		return EdgeWrap, nil
+	case EdgeWrap, EdgeSolid, EdgeBounce:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unsupported edge mode %q, expected wrap, solid or bounce", mode)
+}
+
+// Wraps 地图边缘是否相连，旧的地图没有记录边缘模式，按wrap处理
+func Wraps(gameMap *structs.GameMap) bool {
+	return gameMap.Edges == "" || gameMap.Edges == EdgeWrap
+}
+
+// inBounds 位置是否在地图内
+func inBounds(x, y, width, height int) bool {
+	return x >= 0 && x < width && y >= 0 && y < height
+}
+
+// directionDelta 方向对应的坐标变化
+func directionDelta(direction string) (int, int) {
+	switch direction {
+	case "up":
+		return 0, -1
+	case "down":
+		return 0, 1
+	case "left":
+		return -1, 0
+	case "right":
+		return 1, 0
+	}
+	return 0, 0
+}
+
+// opposite 返回相反的方向
+func opposite(direction string) string {
+	switch direction {
+	case "up":
+		return "down"
+	case "down":
+		return "up"
+	case "left":
+		return "right"
+	case "right":
+		return "left"
+	}
+	return direction
+}
+
+// MoveSnakeInMap 按地图的边缘模式移动一条蛇，返回移动后的蛇和是否撞墙
+// solid模式下撞墙的蛇保持原样并返回true；bounce模式下蛇身反转，从原来的蛇尾离开墙壁
+func MoveSnakeInMap(snake structs.Snake, gameMap *structs.GameMap) (structs.Snake, bool) {
+	if Wraps(gameMap) || len(snake.Positions) == 0 {
+		return MoveSnake(snake, gameMap.Width, gameMap.Height), false
+	}
+	head := snake.Positions[0]
+	dx, dy := directionDelta(snake.Direction)
+	if inBounds(head.X+dx, head.Y+dy, gameMap.Width, gameMap.Height) {
+		return MoveSnake(snake, gameMap.Width, gameMap.Height), false
+	}
+	if gameMap.Edges == EdgeSolid {
+		return snake, true
+	}
+	return bounce(snake, gameMap.Width, gameMap.Height), false
+}
+
+// bounce 让撞墙的蛇掉头：位置前后反转，头像顺序不变，蛇尾所在的格子成为新的蛇头，
+// 然后沿原来蛇尾延伸的方向前进；该方向仍然撞墙时依次尝试两侧，不会走回第二节，都不能走时停在原地
+func bounce(snake structs.Snake, width, height int) structs.Snake {
+	n := len(snake.Positions)
+	reversed := make([]structs.Position, n)
+	for i, pos := range snake.Positions {
+		reversed[n-1-i].X, reversed[n-1-i].Y = pos.X, pos.Y
+		reversed[i].Avatar = pos.Avatar
+	}
+	snake.Positions = reversed
+
+	// 新的方向为从第二节指向新蛇头的方向，只有一节时直接掉头
+	direction := opposite(snake.Direction)
+	if n > 1 {
+		for _, d := range []string{"up", "down", "left", "right"} {
+			dx, dy := directionDelta(d)
+			if reversed[1].X+dx == reversed[0].X && reversed[1].Y+dy == reversed[0].Y {
+				direction = d
+				break
+			}
+		}
+	}
+	head := reversed[0]
+	candidates := []string{direction}
+	if direction == "up" || direction == "down" {
+		candidates = append(candidates, "left", "right")
+	} else {
+		candidates = append(candidates, "up", "down")
+	}
+	for _, d := range candidates {
+		dx, dy := directionDelta(d)
+		x, y := head.X+dx, head.Y+dy
+		if inBounds(x, y, width, height) && (n == 1 || x != reversed[1].X || y != reversed[1].Y) {
+			snake.Direction = d
+			return MoveSnake(snake, width, height)
+		}
+	}
+	snake.Direction = direction
+	return snake
+}
@@ -0,0 +1,54 @@
+package snake
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// TestJoinTickDoesNotHitEdge 边缘不相连的地图上，新蛇在加入的那次刷新中不会撞上地图边缘
+func TestJoinTickDoesNotHitEdge(t *testing.T) {
+	for _, edges := range []string{EdgeSolid, EdgeBounce} {
+		for _, size := range []int{2, 3, 5, 10} {
+			died := 0
+			for seed := int64(1); seed <= 500; seed++ {
+				game := &structs.Game{
+					GroupID: "edges",
+					Seed:    seed,
+					Map:     structs.GameMap{Snakes: make(map[string]structs.Snake), Width: size, Height: size, Edges: edges},
+				}
+				openID := fmt.Sprintf("player-%d", seed)
+				AddSnakeToGameMap(game, openID)
+				before := game.Map.Snakes[openID].Positions[0]
+				result := DefaultEngine.Step(game)
+				after, alive := game.Map.Snakes[openID]
+				if !alive || len(result.Deaths) > 0 {
+					died++
+					continue
+				}
+				if edges == EdgeBounce && headStep(&game.Map, before, after.Positions[0]) != 1 {
+					t.Errorf("%s %dx%d seed %d: bounced on the join tick", edges, size, size, seed)
+				}
+			}
+			if died > 0 {
+				t.Errorf("%s %dx%d: %d of 500 joiners died on their join tick", edges, size, size, died)
+			}
+		}
+	}
+}
+
+func TestWrapPosition(t *testing.T) {
+	tests := []struct{ x, y, wantX, wantY int }{
+		{0, 0, 0, 0},
+		{-1, 0, 9, 0},
+		{10, 5, 0, 5},
+		{3, -1, 3, 7},
+		{3, 8, 3, 0},
+	}
+	for _, tt := range tests {
+		if x, y := WrapPosition(tt.x, tt.y, 10, 8); x != tt.wantX || y != tt.wantY {
+			t.Errorf("WrapPosition(%d, %d) = (%d, %d), want (%d, %d)", tt.x, tt.y, x, y, tt.wantX, tt.wantY)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// 食物的种类和效果
+package snake
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hoshinonyaruko/snake-in-im/food"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// CausePoison 中毒后只剩蛇头时继续失去一节
+const CausePoison = "poison"
+
+// FoodOf 返回地图上的食物，效果为放置时记录的效果，不读取可能已经修改的食物清单
+// 旧的食物没有记录效果，按普通食物增长一节、得分1；没有记录种类时由图片名称推断
+func FoodOf(pos structs.Position) *food.Food {
+	image := strings.TrimSuffix(pos.Avatar, "_small.png")
+	name := pos.Food
+	if name == "" {
+		name = image
+	}
+	f := &food.Food{Name: name, Image: image + ".png", Growth: 1, Score: 1}
+	if pos.Effect != nil {
+		f.Growth = pos.Effect.Growth
+		f.Score = pos.Effect.Score
+		f.Effect = pos.Effect.Effect
+		f.Duration = pos.Effect.Duration
+	}
+	return f
+}
+
+// RandomFood 按稀有度随机选择一种食物，相同的种子和状态选出相同的食物
+func RandomFood(game *structs.Game) *food.Food {
+	return food.Random(Rand(game, fmt.Sprintf("food:random:%d", len(game.Map.Food))))
+}
+
+// trimTail 把蛇缩短到length节，至少保留蛇头
+func trimTail(snake *structs.Snake, length int) {
+	if length < 1 {
+		length = 1
+	}
+	if len(snake.Positions) > length {
+		snake.Positions = snake.Positions[:length]
+	}
+}
+
+// applyEffect 食物的效果作用在蛇上，重复吃到同一种效果时取剩余次数较多的一个
+func applyEffect(snake *structs.Snake, f *food.Food) {
+	extend := func(remaining *int) {
+		if f.Duration > *remaining {
+			*remaining = f.Duration
+		}
+	}
+	switch f.Effect {
+	case food.EffectSpeedUp:
+		extend(&snake.SpeedUp)
+	case food.EffectShield:
+		extend(&snake.Shield)
+	case food.EffectPoison:
+		extend(&snake.Poison)
+	case food.EffectShrink:
+		trimTail(snake, (len(snake.Positions)+1)/2)
+	}
+}
+
+// wearOff 规则6：加速和护盾的剩余次数减一；中毒的蛇失去尾部一节，只剩蛇头时淘汰
+// 返回中毒淘汰的蛇，按OpenID排序
+func (t *Tick) wearOff() []structs.Death {
+	var deaths []structs.Death
+	for _, id := range t.ids {
+		snake, alive := t.gameMap.Snakes[id]
+		if !alive {
+			continue
+		}
+		if snake.SpeedUp > 0 {
+			snake.SpeedUp--
+		}
+		if snake.Shield > 0 {
+			snake.Shield--
+		}
+		if snake.Poison > 0 {
+			snake.Poison--
+			if len(snake.Positions) <= 1 {
+				deaths = append(deaths, structs.Death{OpenID: id, Length: len(snake.Positions), Tick: t.number, Cause: CausePoison})
+				delete(t.gameMap.Snakes, id)
+				continue
+			}
+			trimTail(&snake, len(snake.Positions)-1)
+		}
+		t.gameMap.Snakes[id] = snake
+	}
+	return deaths
+}
@@ -0,0 +1,87 @@
+package snake
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hoshinonyaruko/snake-in-im/food/foodtest"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// feedingGame 一条向右的蛇，蛇头前方一格可以放置食物
+func feedingGame() *structs.Game {
+	game := &structs.Game{
+		GroupID: "effects",
+		Seed:    1,
+		Map:     structs.GameMap{Snakes: make(map[string]structs.Snake), Width: 10, Height: 10},
+	}
+	game.Map.Snakes["alice"] = structs.Snake{
+		OpenID:    "alice",
+		Direction: "right",
+		Positions: []structs.Position{{X: 2, Y: 5, Avatar: "alice_small.jpg"}, {X: 1, Y: 5, Avatar: "alice_blur_small.jpg"}},
+	}
+	return game
+}
+
+// TestPlacedFoodKeepsItsEffect 食物清单热更新后，已经放置的食物仍按放置时的效果生效
+func TestPlacedFoodKeepsItsEffect(t *testing.T) {
+	foodtest.UseManifest(t, `[{"name": "pepper", "image": "chili.png", "growth": 2, "score": 5, "effect": "speed_up", "duration": 4}]`)
+
+	game := feedingGame()
+	if !PlaceFoodAt(game, "pepper", 3, 5) {
+		t.Fatal("food was not placed")
+	}
+	inputs := game.Inputs
+
+	// 保存并重新载入游戏，然后修改清单
+	data, err := json.Marshal(game)
+	if err != nil {
+		t.Fatal(err)
+	}
+	game = &structs.Game{}
+	if err := json.Unmarshal(data, game); err != nil {
+		t.Fatal(err)
+	}
+	foodtest.UseManifest(t, `[{"name": "pepper", "image": "chili.png", "growth": 0, "score": 0, "effect": "shrink"}]`)
+
+	DefaultEngine.Step(game)
+	alice := game.Map.Snakes["alice"]
+	if len(alice.Positions) != 4 || alice.Score != 5 || alice.SpeedUp != 4 {
+		t.Fatalf("length %d, score %d, speed up %d; want 4, 5, 4", len(alice.Positions), alice.Score, alice.SpeedUp)
+	}
+	if tail := alice.Positions[3].Avatar; tail != "chili_blur.png" {
+		t.Errorf("tail avatar %q, want chili_blur.png", tail)
+	}
+
+	// 回放同样使用记录的效果
+	replay := feedingGame()
+	replay.Inputs = nil
+	for _, input := range inputs {
+		applyInput(replay, input)
+	}
+	DefaultEngine.Step(replay)
+	if got := replay.Map.Snakes["alice"]; got.Score != alice.Score || got.SpeedUp != alice.SpeedUp || len(got.Positions) != len(alice.Positions) {
+		t.Errorf("replay ate %+v, want %+v", got, alice)
+	}
+}
+
+// TestLegacyFoodIsPlain 旧的食物没有记录效果，即使清单中有同名的食物也按普通食物处理
+func TestLegacyFoodIsPlain(t *testing.T) {
+	foodtest.UseManifest(t, `[{"name": "pepper", "growth": 3, "score": 7, "effect": "shield"}]`)
+
+	for _, pos := range []structs.Position{
+		{X: 3, Y: 5, Avatar: "pepper_small.png", Food: "pepper"},
+		{X: 3, Y: 5, Avatar: "pepper_small.png"},
+	} {
+		game := feedingGame()
+		game.Map.Food = []structs.Position{pos}
+		DefaultEngine.Step(game)
+		alice := game.Map.Snakes["alice"]
+		if len(alice.Positions) != 3 || alice.Score != 1 || alice.Shield != 0 {
+			t.Errorf("%+v: length %d, score %d, shield %d; want 3, 1, 0", pos, len(alice.Positions), alice.Score, alice.Shield)
+		}
+		if f := FoodOf(pos); f.Name != "pepper" || f.Blur() != "pepper_blur.png" {
+			t.Errorf("FoodOf(%+v) = %+v", pos, f)
+		}
+	}
+}
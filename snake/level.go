@@ -0,0 +1,125 @@
+// 关卡中的墙、障碍物和出生区域
+package snake
+
+import (
+	"math/rand"
+
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// CauseObstacle 撞上关卡中的障碍物
+const CauseObstacle = "obstacle"
+
+// tileIndex 以格子为key的关卡格子种类
+func tileIndex(gameMap *structs.GameMap) map[cell]string {
+	index := make(map[cell]string, len(gameMap.Tiles))
+	for _, tile := range gameMap.Tiles {
+		index[cell{tile.X, tile.Y}] = tile.Kind
+	}
+	return index
+}
+
+// blocking 格子种类是否不能进入
+func blocking(kind string) bool {
+	return kind == structs.TileWall || kind == structs.TileObstacle
+}
+
+// crashCause 蛇头撞上关卡格子时的淘汰原因，不是墙或障碍物时返回空
+func crashCause(kind string) string {
+	switch kind {
+	case structs.TileWall:
+		return CauseWall
+	case structs.TileObstacle:
+		return CauseObstacle
+	}
+	return ""
+}
+
+// Blocked 位置是否是关卡中的墙或障碍物
+func Blocked(gameMap *structs.GameMap, x, y int) bool {
+	for _, tile := range gameMap.Tiles {
+		if tile.X == x && tile.Y == y && blocking(tile.Kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// freeCells 返回没有墙、障碍物、蛇和食物的格子，按先行后列的顺序排列
+// spawnOnly为true且地图有出生区域时只返回出生区域内的格子
+func freeCells(gameMap *structs.GameMap, spawnOnly bool) []cell {
+	tiles := tileIndex(gameMap)
+	occupied := make(map[cell]bool)
+	for _, snake := range gameMap.Snakes {
+		for _, pos := range snake.Positions {
+			occupied[cell{pos.X, pos.Y}] = true
+		}
+	}
+	for _, food := range gameMap.Food {
+		occupied[cell{food.X, food.Y}] = true
+	}
+	hasSpawn := false
+	for _, kind := range tiles {
+		if kind == structs.TileSpawn {
+			hasSpawn = true
+			break
+		}
+	}
+
+	var cells []cell
+	for y := 0; y < gameMap.Height; y++ {
+		for x := 0; x < gameMap.Width; x++ {
+			c := cell{x, y}
+			if occupied[c] || blocking(tiles[c]) {
+				continue
+			}
+			if spawnOnly && hasSpawn && tiles[c] != structs.TileSpawn {
+				continue
+			}
+			cells = append(cells, c)
+		}
+	}
+	return cells
+}
+
+// HasFreeCell 地图上是否还有可以放置食物的空闲格子
+func HasFreeCell(gameMap *structs.GameMap) bool {
+	return len(freeCells(gameMap, false)) > 0
+}
+
+// spawnInLevel 在关卡地图上为新蛇选择位置和方向
+// 位置在空闲的出生区域内，出生区域已满时使用任意空闲格子；优先选择前方没有墙的方向
+func spawnInLevel(rng *rand.Rand, gameMap *structs.GameMap, avatar string) (structs.Position, string) {
+	cells := freeCells(gameMap, true)
+	if len(cells) == 0 {
+		cells = freeCells(gameMap, false)
+	}
+	pos := GenerateRandomPositionWithAvatar(rng, gameMap.Width, gameMap.Height, avatar)
+	if len(cells) > 0 {
+		c := cells[rng.Intn(len(cells))]
+		pos.X, pos.Y = c.X, c.Y
+	}
+	return pos, openDirection(rng, gameMap, pos)
+}
+
+// openDirection 随机选择一个前方没有地图边缘、墙或障碍物的方向，四面都被挡住时任选一个
+func openDirection(rng *rand.Rand, gameMap *structs.GameMap, pos structs.Position) string {
+	directions := []string{"up", "down", "left", "right"}
+	var open []string
+	for _, d := range directions {
+		dx, dy := directionDelta(d)
+		x, y := pos.X+dx, pos.Y+dy
+		if Wraps(gameMap) {
+			x, y = WrapPosition(x, y, gameMap.Width, gameMap.Height)
+		} else if !inBounds(x, y, gameMap.Width, gameMap.Height) {
+			continue
+		}
+		if !Blocked(gameMap, x, y) {
+			open = append(open, d)
+		}
+	}
+	if len(open) == 0 {
+		open = directions
+	}
+	return open[rng.Intn(len(open))]
+}
@@ -0,0 +1,72 @@
+// 输入记录和对局回放
+package snake
+
+import (
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// RecordInput 记录一次输入，在当前刷新次数之后生效，保存游戏时一起写入输入记录
+func RecordInput(game *structs.Game, input structs.Input) {
+	input.Tick = game.Tick
+	game.Inputs = append(game.Inputs, input)
+}
+
+// RecordFood 把地图上已有的食物记录为输入，用于创建游戏时放置的初始食物
+func RecordFood(game *structs.Game) {
+	for _, pos := range game.Map.Food {
+		pos := pos
+		RecordInput(game, structs.Input{Kind: structs.InputFood, Food: &pos})
+	}
+}
+
+// Replay 按种子和输入记录从空白地图重新执行对局，直到第ticks次刷新之后的所有输入生效
+// game只提供种子、地图大小、边缘模式和关卡的格子，不会被修改
+// 回放不依赖时钟和食物清单，相同的种子和输入总会得到相同的地图
+func (e *Engine) Replay(game *structs.Game, inputs []structs.Input, ticks int64) *structs.Game {
+	replay := &structs.Game{
+		GroupID:         game.GroupID,
+		RefreshInterval: game.RefreshInterval,
+		Seed:            game.Seed,
+		Level:           game.Level,
+		Map: structs.GameMap{
+			Snakes: make(map[string]structs.Snake),
+			Food:   []structs.Position{},
+			Width:  game.Map.Width,
+			Height: game.Map.Height,
+			Edges:  game.Map.Edges,
+			Tiles:  append([]structs.Tile(nil), game.Map.Tiles...),
+		},
+	}
+
+	next := 0
+	for {
+		// 先应用在本次刷新之前产生的输入
+		for next < len(inputs) && inputs[next].Tick <= replay.Tick {
+			applyInput(replay, inputs[next])
+			next++
+		}
+		if replay.Tick >= ticks {
+			return replay
+		}
+		e.Step(replay)
+	}
+}
+
+// applyInput 把一条输入作用在回放的游戏上
+func applyInput(game *structs.Game, input structs.Input) {
+	switch input.Kind {
+	case structs.InputJoin:
+		if _, exists := game.Map.Snakes[input.OpenID]; !exists {
+			AddSnakeToGameMap(game, input.OpenID)
+		}
+	case structs.InputDirection:
+		if snake, ok := game.Map.Snakes[input.OpenID]; ok {
+			snake.Direction = input.Direction
+			game.Map.Snakes[input.OpenID] = snake
+		}
+	case structs.InputFood:
+		if input.Food != nil {
+			game.Map.Food = append(game.Map.Food, *input.Food)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package snake
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// stepClock 每次请求前由测试推进的时钟
+type stepClock struct{ now time.Time }
+
+func (c *stepClock) Now() time.Time { return c.now }
+
+// playScript 按固定的脚本进行一局游戏，输入的时间点不是刷新间隔的整数倍
+func playScript(t *testing.T, seed int64, edges string) *structs.Game {
+	t.Helper()
+	start := time.Unix(1700000000, 0)
+	clock := &stepClock{now: start}
+	engine := NewEngine(clock)
+
+	game := &structs.Game{
+		GroupID:         "g",
+		RefreshInterval: 10,
+		Seed:            seed,
+		LastRefresh:     start.Unix(),
+		Map: structs.GameMap{
+			Snakes: make(map[string]structs.Snake),
+			Width:  12,
+			Height: 12,
+			Edges:  edges,
+		},
+	}
+	AddFoodToGameMap(game, "food")
+
+	directions := []string{"up", "left", "down", "right"}
+	players := []string{"alice", "bob", "carol", "dave", "erin"}
+	for i := 0; i < 120; i++ {
+		clock.now = clock.now.Add(7 * time.Second)
+		openID := players[i%len(players)]
+		if _, err := engine.Update(game, openID); err != nil {
+			t.Fatal(err)
+		}
+		if i%3 == 0 {
+			if s, ok := game.Map.Snakes[openID]; ok {
+				s.Direction = directions[(i/3)%len(directions)]
+				game.Map.Snakes[openID] = s
+				RecordInput(game, structs.Input{Kind: structs.InputDirection, OpenID: openID, Direction: s.Direction})
+			}
+		}
+		if i%4 == 0 && HasFreeCell(&game.Map) {
+			AddFoodToGameMap(game, "food")
+		}
+	}
+	return game
+}
+
+func mapJSON(t *testing.T, gameMap structs.GameMap) string {
+	t.Helper()
+	data, err := json.Marshal(gameMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSameSeedAndInputsGiveSameGame(t *testing.T) {
+	for _, edges := range []string{EdgeWrap, EdgeSolid, EdgeBounce} {
+		t.Run(edges, func(t *testing.T) {
+			first := playScript(t, 42, edges)
+			second := playScript(t, 42, edges)
+			if first.Tick != second.Tick || first.LastRefresh != second.LastRefresh {
+				t.Fatalf("tick %d/%d, last refresh %d/%d", first.Tick, second.Tick, first.LastRefresh, second.LastRefresh)
+			}
+			if !reflect.DeepEqual(first.Inputs, second.Inputs) {
+				t.Fatal("inputs differ between runs")
+			}
+			if a, b := mapJSON(t, first.Map), mapJSON(t, second.Map); a != b {
+				t.Fatalf("maps differ:\n%s\n%s", a, b)
+			}
+		})
+	}
+}
+
+func TestReplayMatchesRecordedGame(t *testing.T) {
+	for _, edges := range []string{EdgeWrap, EdgeSolid, EdgeBounce} {
+		t.Run(edges, func(t *testing.T) {
+			game := playScript(t, 7, edges)
+			replay := DefaultEngine.Replay(game, game.Inputs, game.Tick)
+			if replay.Tick != game.Tick {
+				t.Fatalf("replay stopped at tick %d, want %d", replay.Tick, game.Tick)
+			}
+			if a, b := mapJSON(t, game.Map), mapJSON(t, replay.Map); a != b {
+				t.Fatalf("replay differs:\n%s\n%s", a, b)
+			}
+		})
+	}
+}
+
+func TestLastRefreshKeepsRemainder(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := &stepClock{now: start}
+	engine := NewEngine(clock)
+	game := &structs.Game{
+		RefreshInterval: 10,
+		LastRefresh:     start.Unix(),
+		Map:             structs.GameMap{Snakes: make(map[string]structs.Snake), Width: 10, Height: 10},
+	}
+
+	// 新玩家加入时额外刷新一次，刷新时间不变
+	if _, err := engine.Update(game, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if game.Tick != 1 || game.LastRefresh != 1000 {
+		t.Fatalf("after join: tick %d, last refresh %d", game.Tick, game.LastRefresh)
+	}
+
+	// 每隔7秒请求一次，第3次请求时累计的21秒正好执行两次刷新
+	var ticks []int64
+	for i := 0; i < 3; i++ {
+		clock.now = clock.now.Add(7 * time.Second)
+		if _, err := engine.Advance(game); err != nil {
+			t.Fatal(err)
+		}
+		ticks = append(ticks, game.Tick)
+	}
+	if fmt.Sprint(ticks) != "[1 2 3]" || game.LastRefresh != 1020 {
+		t.Fatalf("ticks %v, last refresh %d", ticks, game.LastRefresh)
+	}
+}
@@ -10,19 +10,13 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
-	"strings"
-	"time"
 
 	"github.com/disintegration/imaging"
+	"github.com/hoshinonyaruko/snake-in-im/food"
 	"github.com/hoshinonyaruko/snake-in-im/memimg"
 	"github.com/hoshinonyaruko/snake-in-im/structs"
 )
 
-var (
-	toDelete            = make(map[string]bool) // 包级变量，记录待删除的蛇
-	collisionCheckCount = 0                     // 用于记录碰撞检查的次数
-)
-
 // 处理和保存头像到avatar文件夹
 func ProcessAndSaveAvatar(avatarUrl, openID string, blockSize int) error {
 	// 下载头像图片
@@ -105,79 +99,16 @@ func ProcessAndSaveAvatar(avatarUrl, openID string, blockSize int) error {
 	return nil
 }
 
-func UpdateGameMapIfNeeded(game *structs.Game, openID string) ([]structs.Position, error) {
-	currentTime := time.Now().Unix()
-	elapsed := currentTime - game.LastRefresh
-	fmt.Printf("elapsed[%v] game.LastRefresh[%v]\n", elapsed, game.LastRefresh)
-
-	// 计算应该执行的移动次数
-	moveInterval := int64(game.RefreshInterval) // 移动间隔，以秒为单位
-	moveCount := elapsed / moveInterval
-	if moveCount == 0 {
-		fmt.Printf("没有到刷新时间,openID[%v]\n", openID)
-	} else {
-		fmt.Printf("移动次数[%v]\n", moveCount)
-	}
-
-	// 检查并添加新蛇
-	if _, exists := game.Map.Snakes[openID]; !exists {
-		newPos := GenerateRandomPositionWithAvatar(game.Map.Width, game.Map.Height, fmt.Sprintf("%s_small.jpg", openID)) //小头像
-
-		// 随机选择一个方向
-		directions := []string{"up", "down", "left", "right"}
-		randomDirection := directions[rand.Intn(len(directions))] // 随机选择一个索引
-
-		// 创建并添加新蛇
-		game.Map.Snakes[openID] = structs.Snake{
-			Positions: []structs.Position{newPos},
-			OpenID:    openID,
-			Direction: randomDirection, // 使用随机方向
-		}
-		moveCount = 1
-	}
-
-	//没有到刷新时间
-	if moveCount == 0 {
-		return nil, nil
-	}
-
-	// 初始化存放所有被吃掉的食物位置的数组
-	allEatenFoodPositions := []structs.Position{}
-
-	// 如果计数可以被5整除，则清理toDelete
-	if collisionCheckCount%5 == 0 {
-		toDelete = make(map[string]bool)
-	}
-
-	// 循环执行移动和碰撞检测
-	for i := int64(0); i < moveCount; i++ {
-		for id := range game.Map.Snakes {
-			// 先检测所有蛇是否咬到自己
-			CheckSelfCollision(game.Map.Snakes)
-
-			// 检测碰撞并返回当前被吃掉的食物位置 处理吃掉食物 蛇互相吃掉
-			eatenFoodPositions := CheckCollisions(&game.Map)
-
-			// 根据是否吃到食物移动蛇
-			game.Map.Snakes[id] = MoveSnake(game.Map.Snakes[id], game.Map.Width, game.Map.Height)
-
-			// 将这次循环中被吃掉的食物位置追加到所有被吃掉的食物位置数组中
-			allEatenFoodPositions = append(allEatenFoodPositions, eatenFoodPositions...)
-
-		}
-	}
-
-	// 刷新新的时间
-	game.LastRefresh = currentTime
-
-	return allEatenFoodPositions, nil
+// UpdateGameMapIfNeeded 使用默认引擎刷新游戏地图
+func UpdateGameMapIfNeeded(game *structs.Game, openID string) (TickResult, error) {
+	return DefaultEngine.Update(game, openID)
 }
 
 // 辅助函数：生成带有头像的随机位置 一条新的蛇
-func GenerateRandomPositionWithAvatar(width, height int, avatar string) structs.Position {
+func GenerateRandomPositionWithAvatar(rng *rand.Rand, width, height int, avatar string) structs.Position {
 	return structs.Position{
-		X:      rand.Intn(width),
-		Y:      rand.Intn(height),
+		X:      rng.Intn(width),
+		Y:      rng.Intn(height),
 		Avatar: avatar, // 为新位置设置头像
 	}
 }
@@ -242,117 +173,12 @@ func WrapPosition(x, y, width, height int) (int, int) {
 	return x, y
 }
 
-// 蛇吃到了自己函数
-func CheckSelfCollision(snakes map[string]structs.Snake) {
-	for id, snake := range snakes {
-		if len(snake.Positions) < 2 {
-			// 如果蛇的长度小于2，它不可能咬到自己
-			continue
-		}
-		head := snake.Positions[0]
-		// 检查头部是否与身体的其他部分重叠
-		for _, bodyPart := range snake.Positions[1:] {
-			if head.X == bodyPart.X && head.Y == bodyPart.Y {
-				// 发现碰撞，删除这条蛇
-				delete(snakes, id)
-				break // 退出当前蛇的检查循环
-			}
-		}
-	}
-}
-
-// 更新的CheckCollisions函数，确保立即处理toDelete标记
-func CheckCollisions(gameMap *structs.GameMap) []structs.Position {
-	positionMap := make(map[structs.Position]string)
-
-	// 记录每个蛇身体的位置到蛇的ID，但在检查时排除当前检查蛇的身体
-	for id, snake := range gameMap.Snakes {
-		if len(snake.Positions) == 0 || toDelete[id] {
-			continue // 如果蛇已标记为删除或没有位置信息，跳过这条蛇
-		}
-		for i, pos := range snake.Positions {
-			if i != 0 { // 还是保留跳过蛇头的条件以防止将蛇头位置也放入
-				pos.Avatar = ""
-				positionMap[pos] = id
-			}
-		}
-	}
-
-	// 检查头部与其他蛇的身体部分是否重叠
-	for id, snake := range gameMap.Snakes {
-		if len(snake.Positions) == 0 || toDelete[id] {
-			continue // 同样，如果蛇已标记为删除或没有位置信息，跳过这条蛇
-		}
-		head := snake.Positions[0]
-		head.Avatar = ""
-
-		// 清除当前蛇的身体位置信息，以防止自身碰撞的误判
-		for _, pos := range snake.Positions {
-			delete(positionMap, pos)
-		}
-
-		// 检查蛇头是否与其他蛇的身体部分重叠
-		if otherID, exists := positionMap[head]; exists && otherID != id {
-			fmt.Printf("处理碰撞,id%v,otherid%v", id, otherID)
-			ResolveCollision(gameMap.Snakes, id, otherID)
-		}
-
-		// 检查结束后重新添加当前蛇的身体到positionMap，为后续检查准备
-		for i, pos := range snake.Positions {
-			if i != 0 {
-				positionMap[pos] = id
-			}
-		}
-	}
-
-	// 检查蛇头是否与食物重叠
-	eatenFoodPositions := CheckFoodCollisions(gameMap)
-
-	// 删除被吃掉的蛇
-	for id := range toDelete {
-		delete(gameMap.Snakes, id)
-	}
-
-	return eatenFoodPositions
-}
-
-func ResolveCollision(snakes map[string]structs.Snake, snake1ID, snake2ID string) {
-	if toDelete[snake1ID] || toDelete[snake2ID] {
-		// 如果其中一条蛇已被标记为删除，则不执行吃操作
-		return
-	}
-
-	snake1 := snakes[snake1ID]
-	snake2 := snakes[snake2ID]
-
-	if len(snake1.Positions) >= len(snake2.Positions) {
-		EatSnake(snakes, snake1ID, snake2ID)
-	} else {
-		EatSnake(snakes, snake2ID, snake1ID)
-	}
-}
-
-func EatSnake(snakes map[string]structs.Snake, eaterID, eatenID string) {
-	eater := snakes[eaterID]
-	eaten := snakes[eatenID]
-
-	if len(eaten.Positions) > 0 {
-		fmt.Printf("蛇吃掉了蛇\n")
-		fmt.Printf("蛇吃掉了蛇,toDelete:%v\n", toDelete)
-		lastPosition := eaten.Positions[len(eaten.Positions)-1]
-		eater.Positions = append(eater.Positions, lastPosition)
-		eater.Positions[len(eater.Positions)-1].Avatar = fmt.Sprintf("%s_blur_small.jpg", eaten.OpenID)
-	}
-
-	toDelete[eatenID] = true
-	snakes[eaterID] = eater
-}
-
 func CheckFoodCollisions(gameMap *structs.GameMap) []structs.Position {
 	eatenFoodPositions := []structs.Position{} // 用于存放被吃掉的食物
 	foodEaten := make(map[int]bool)            // 标记已被吃掉的食物位置
 
-	for _, snake := range gameMap.Snakes {
+	for _, id := range SortedSnakeIDs(gameMap.Snakes) {
+		snake := gameMap.Snakes[id]
 		if len(snake.Positions) == 0 { // 检查蛇是否有位置，避免访问空数组
 			continue // 如果这条蛇没有任何位置数据，跳过这条蛇
 		}
@@ -360,7 +186,7 @@ func CheckFoodCollisions(gameMap *structs.GameMap) []structs.Position {
 		for i, foodPos := range gameMap.Food {
 			if !foodEaten[i] && snakeHead.X == foodPos.X && snakeHead.Y == foodPos.Y {
 				// 蛇吃食物
-				EatFood(&snake, foodPos, gameMap.Width, gameMap.Height)
+				EatFood(&snake, foodPos, gameMap)
 				gameMap.Snakes[snake.OpenID] = snake // 更新蛇的状态
 				eatenFoodPositions = append(eatenFoodPositions, foodPos)
 				foodEaten[i] = true // 标记食物已被吃掉
@@ -379,64 +205,86 @@ func CheckFoodCollisions(gameMap *structs.GameMap) []structs.Position {
 	return eatenFoodPositions       // 返回被吃掉的食物位置
 }
 
-func EatFood(snake *structs.Snake, foodPos structs.Position, width int, height int) {
+// EatFood 按食物的种类让蛇增长或缩短、得分并获得效果
+func EatFood(snake *structs.Snake, foodPos structs.Position, gameMap *structs.GameMap) {
+	f := FoodOf(foodPos)
+
 	// 将食物作为模糊形式添加到蛇的末尾
-	foodPos.Avatar = fmt.Sprintf("%s_blur.png", foodPos.Avatar)
-	foodPos.Avatar = strings.ReplaceAll(foodPos.Avatar, "_small.png", "")
+	for i := 0; i < f.Growth; i++ {
+		GrowTail(snake, f.Blur(), gameMap)
+	}
+	if f.Growth < 0 {
+		trimTail(snake, len(snake.Positions)+f.Growth)
+	}
+	snake.Score += f.Score
+	applyEffect(snake, f)
+}
+
+// GrowTail 在蛇的尾部增加一节，新的一节使用指定的头像
+// 边缘不相连时，超出地图的新尾部与原来的尾部重叠，蛇移动后再展开
+func GrowTail(snake *structs.Snake, avatar string, gameMap *structs.GameMap) {
+	if len(snake.Positions) == 0 {
+		return
+	}
+	tail := structs.Position{Avatar: avatar}
 
 	if len(snake.Positions) == 1 {
 		// 仅有头部，根据头部方向决定尾部的新位置
 		head := snake.Positions[0]
-		foodPos.X = head.X // 从头部复制位置开始
-		foodPos.Y = head.Y // 从头部复制位置开始
+		tail.X = head.X // 从头部复制位置开始
+		tail.Y = head.Y // 从头部复制位置开始
 		switch snake.Direction {
 		case "up":
-			foodPos.Y += 1 // 向下增长
+			tail.Y += 1 // 向下增长
 		case "down":
-			foodPos.Y -= 1 // 向上增长
+			tail.Y -= 1 // 向上增长
 		case "left":
-			foodPos.X += 1 // 向右增长
+			tail.X += 1 // 向右增长
 		case "right":
-			foodPos.X -= 1 // 向左增长
+			tail.X -= 1 // 向左增长
 		}
 	} else {
 		// 蛇长度大于1，添加新尾部
 		lastPos := snake.Positions[len(snake.Positions)-1]
 		secondLastPos := snake.Positions[len(snake.Positions)-2]
-		foodPos.X = lastPos.X
-		foodPos.Y = lastPos.Y
-		if foodPos.X == secondLastPos.X {
-			if foodPos.Y < secondLastPos.Y {
-				foodPos.Y -= 1
+		tail.X = lastPos.X
+		tail.Y = lastPos.Y
+		if tail.X == secondLastPos.X {
+			if tail.Y < secondLastPos.Y {
+				tail.Y -= 1
 			} else {
-				foodPos.Y += 1
+				tail.Y += 1
 			}
-		} else if foodPos.Y == secondLastPos.Y {
-			if foodPos.X < secondLastPos.X {
-				foodPos.X -= 1
+		} else if tail.Y == secondLastPos.Y {
+			if tail.X < secondLastPos.X {
+				tail.X -= 1
 			} else {
-				foodPos.X += 1
+				tail.X += 1
 			}
 		}
-
-		foodPos.X, foodPos.Y = WrapPosition(foodPos.X, foodPos.Y, width, height) // 确保新尾部位置在边界内
 	}
 
-	snake.Positions = append(snake.Positions, foodPos)
+	if Wraps(gameMap) {
+		tail.X, tail.Y = WrapPosition(tail.X, tail.Y, gameMap.Width, gameMap.Height) // 确保新尾部位置在边界内
+	} else if !inBounds(tail.X, tail.Y, gameMap.Width, gameMap.Height) {
+		last := snake.Positions[len(snake.Positions)-1]
+		tail.X, tail.Y = last.X, last.Y
+	}
+	snake.Positions = append(snake.Positions, tail)
 }
 
 // AddFoodToGameMap adds a new food item to the game map
+// 食物的图片和效果由放置时的食物清单决定，清单中没有的食物使用<foodName>.png并增长一节
 func AddFoodToGameMap(gameMap *structs.Game, foodName string) {
-	newFood := structs.Position{
-		X:      0,
-		Y:      0,
-		Avatar: foodName + "_small.png",
-	}
+	newFood := food.Lookup(foodName).Position(0, 0)
+
+	// Use the game's seeded generator so the placement can be replayed
+	rng := Rand(gameMap, fmt.Sprintf("food:%s:%d", foodName, len(gameMap.Map.Food)))
 
 	// Find a unique position that does not overlap with snakes or other food
 	for {
-		newFood.X = rand.Intn(gameMap.Map.Width)
-		newFood.Y = rand.Intn(gameMap.Map.Height)
+		newFood.X = rng.Intn(gameMap.Map.Width)
+		newFood.Y = rng.Intn(gameMap.Map.Height)
 		if !positionOverlap(gameMap, newFood) {
 			break
 		}
@@ -444,10 +292,27 @@ func AddFoodToGameMap(gameMap *structs.Game, foodName string) {
 
 	// Add the new food position to the map
 	gameMap.Map.Food = append(gameMap.Map.Food, newFood)
+	RecordInput(gameMap, structs.Input{Kind: structs.InputFood, Food: &newFood})
 }
 
-// Check if the proposed new position overlaps with any snakes or existing food
+// PlaceFoodAt 在指定位置放置食物，位置在地图外或已被占用时返回false
+func PlaceFoodAt(gameMap *structs.Game, foodName string, x, y int) bool {
+	pos := food.Lookup(foodName).Position(x, y)
+	if !inBounds(x, y, gameMap.Map.Width, gameMap.Map.Height) || positionOverlap(gameMap, pos) {
+		return false
+	}
+	gameMap.Map.Food = append(gameMap.Map.Food, pos)
+	RecordInput(gameMap, structs.Input{Kind: structs.InputFood, Food: &pos})
+	return true
+}
+
+// Check if the proposed new position overlaps with any snakes, existing food, walls or obstacles
 func positionOverlap(gameMap *structs.Game, pos structs.Position) bool {
+	// Check overlap with the level
+	if Blocked(&gameMap.Map, pos.X, pos.Y) {
+		return true
+	}
+
 	// Check overlap with food
 	for _, food := range gameMap.Map.Food {
 		if food.X == pos.X && food.Y == pos.Y {
@@ -0,0 +1,188 @@
+// 可注入时钟和可复现随机数的刷新引擎
+package snake
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// Clock 提供当前时间，测试和回放时可以替换为固定时钟
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// SystemClock 使用真实系统时间的时钟
+var SystemClock Clock = systemClock{}
+
+// FixedClock 总是返回同一时间，用于回放指定时刻的请求
+type FixedClock time.Time
+
+func (c FixedClock) Now() time.Time { return time.Time(c) }
+
+// Engine 按固定间隔推进游戏，时间只来自Clock，随机数只来自游戏自身的种子
+type Engine struct {
+	Clock Clock
+}
+
+// NewEngine 创建一个刷新引擎，clock为nil时使用系统时钟
+func NewEngine(clock Clock) *Engine {
+	if clock == nil {
+		clock = SystemClock
+	}
+	return &Engine{Clock: clock}
+}
+
+// DefaultEngine 供包级函数使用的默认引擎
+var DefaultEngine = NewEngine(SystemClock)
+
+// NewSeed 根据引擎时钟生成一个新的对局种子
+func (e *Engine) NewSeed() int64 {
+	return e.Clock.Now().UnixNano()
+}
+
+// Rand 返回由游戏种子、当前刷新次数和salt共同决定的随机数生成器
+// 同样的种子、同样的状态和同样的输入总会得到同样的随机序列
+func Rand(game *structs.Game, salt string) *rand.Rand {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d:%d:%s", game.Seed, game.Tick, salt)
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
+
+// Update 把新玩家加入地图，并根据经过的时间执行需要的刷新次数
+// 返回所有刷新中被吃掉的食物和被淘汰的蛇
+func (e *Engine) Update(game *structs.Game, openID string) (TickResult, error) {
+	return e.UpdateEach(game, openID, nil)
+}
+
+// UpdateEach 与Update相同，并在刷新前和每次刷新后调用onTick，用于逐帧回放
+// onTick收到的是游戏本身，需要保留时应自行拷贝
+func (e *Engine) UpdateEach(game *structs.Game, openID string, onTick func(game *structs.Game)) (TickResult, error) {
+	// 检查并添加新蛇，新玩家加入时至少刷新一次
+	joined := false
+	if _, exists := game.Map.Snakes[openID]; !exists {
+		AddSnakeToGameMap(game, openID)
+		RecordInput(game, structs.Input{Kind: structs.InputJoin, OpenID: openID})
+		joined = true
+	}
+	return e.advance(game, joined, onTick)
+}
+
+// Advance 只根据经过的时间执行需要的刷新次数，不加入新玩家
+func (e *Engine) Advance(game *structs.Game) (TickResult, error) {
+	return e.advance(game, false, nil)
+}
+
+func (e *Engine) advance(game *structs.Game, joined bool, onTick func(game *structs.Game)) (TickResult, error) {
+	currentTime := e.Clock.Now().Unix()
+	elapsed := currentTime - game.LastRefresh
+
+	// 计算应该执行的移动次数
+	moveInterval := int64(game.RefreshInterval) // 移动间隔，以秒为单位
+	if moveInterval <= 0 {
+		return TickResult{}, fmt.Errorf("invalid refresh interval %d for group %s", game.RefreshInterval, game.GroupID)
+	}
+	due := elapsed / moveInterval
+	if due < 0 {
+		due = 0
+	}
+	moveCount := due
+	if joined && moveCount == 0 {
+		moveCount = 1
+	}
+
+	if onTick != nil {
+		onTick(game)
+	}
+
+	//没有到刷新时间
+	if moveCount == 0 {
+		return TickResult{}, nil
+	}
+
+	// 初始化存放所有被吃掉的食物位置的数组
+	all := TickResult{EatenFood: []structs.Position{}}
+
+	// 循环执行移动和碰撞检测
+	for i := int64(0); i < moveCount; i++ {
+		result := e.Step(game)
+		all.EatenFood = append(all.EatenFood, result.EatenFood...)
+		all.Deaths = append(all.Deaths, result.Deaths...)
+		if onTick != nil {
+			onTick(game)
+		}
+	}
+
+	// 只按已经到期的整数个间隔推进刷新时间，不足一个间隔的时间留到下一次
+	// 新玩家加入时额外的一次刷新不改变刷新时间，之后的刷新仍然按原来的节奏进行
+	game.LastRefresh += due * moveInterval
+
+	return all, nil
+}
+
+// Step 执行一次刷新，所有蛇同时移动后按ResolveTick的规则结算
+func (e *Engine) Step(game *structs.Game) TickResult {
+	game.Tick++
+	return ResolveTick(&game.Map, game.Tick)
+}
+
+// SortedSnakeIDs 返回按OpenID排序的蛇ID列表
+func SortedSnakeIDs(snakes map[string]structs.Snake) []string {
+	ids := make([]string, 0, len(snakes))
+	for id := range snakes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// AddSnakeToGameMap 在随机位置以随机方向为玩家创建一条新蛇
+func AddSnakeToGameMap(game *structs.Game, openID string) {
+	rng := Rand(game, "snake:"+openID)
+	avatar := fmt.Sprintf("%s_small.jpg", openID) //小头像
+
+	var newPos structs.Position
+	var randomDirection string
+	if len(game.Map.Tiles) > 0 {
+		// 关卡地图在出生区域内选择空闲的格子
+		newPos, randomDirection = spawnInLevel(rng, &game.Map, avatar)
+	} else if !Wraps(&game.Map) {
+		// 边缘不相连时不能朝着紧挨的地图边缘出生，否则加入的那次刷新就会撞墙
+		newPos = GenerateRandomPositionWithAvatar(rng, game.Map.Width, game.Map.Height, avatar)
+		randomDirection = openDirection(rng, &game.Map, newPos)
+	} else {
+		newPos = GenerateRandomPositionWithAvatar(rng, game.Map.Width, game.Map.Height, avatar)
+
+		// 随机选择一个方向
+		directions := []string{"up", "down", "left", "right"}
+		randomDirection = directions[rng.Intn(len(directions))] // 随机选择一个索引
+	}
+
+	// 创建并添加新蛇
+	game.Map.Snakes[openID] = structs.Snake{
+		Positions: []structs.Position{newPos},
+		OpenID:    openID,
+		Direction: randomDirection, // 使用随机方向
+	}
+}
+
+// CloneGameMap 深拷贝地图，修改拷贝不会影响原地图
+func CloneGameMap(gameMap structs.GameMap) structs.GameMap {
+	clone := gameMap
+	clone.Snakes = make(map[string]structs.Snake, len(gameMap.Snakes))
+	for id, snake := range gameMap.Snakes {
+		snake.Positions = append([]structs.Position(nil), snake.Positions...)
+		clone.Snakes[id] = snake
+	}
+	clone.Food = append([]structs.Position(nil), gameMap.Food...)
+	clone.Tiles = append([]structs.Tile(nil), gameMap.Tiles...)
+	return clone
+}
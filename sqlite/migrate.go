@@ -0,0 +1,278 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// migration 一次数据库结构升级，version从1开始连续递增
+type migration struct {
+	version     int
+	description string
+	statements  []string
+	apply       func(tx *sql.Tx) error // 需要判断现有结构时使用，在statements之后执行
+}
+
+const createSchemaVersionTableSQL = `
+CREATE TABLE IF NOT EXISTS schema_version (
+    Version INTEGER NOT NULL
+);
+`
+
+// migrations 按版本顺序排列，已发布的迁移不能修改，只能追加新的版本
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create games, snakes and foods tables",
+		// 使用IF NOT EXISTS，没有版本记录的旧数据库可以直接接管
+		statements: []string{`
+CREATE TABLE IF NOT EXISTS Games (
+    GroupID TEXT PRIMARY KEY,
+    MapWidth INTEGER,
+    MapHeight INTEGER,
+    LastRefresh TIMESTAMP,
+    RefreshInterval INTEGER
+);`, `
+CREATE TABLE IF NOT EXISTS Snakes (
+    GroupID TEXT,
+    OpenID TEXT,
+    Positions TEXT,
+    Direction TEXT,
+    PRIMARY KEY (GroupID, OpenID)
+);`, `
+CREATE TABLE IF NOT EXISTS Foods (
+    ID INTEGER PRIMARY KEY AUTOINCREMENT,
+    GroupID TEXT,
+    Position TEXT
+);`, `
+CREATE INDEX IF NOT EXISTS idx_snake_group ON Snakes (GroupID);`,
+		},
+	},
+	{
+		version:     2,
+		description: "add seed and tick to games",
+		apply: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "Games", "Seed", "INTEGER DEFAULT 0"); err != nil {
+				return err
+			}
+			return addColumnIfMissing(tx, "Games", "Tick", "INTEGER DEFAULT 0")
+		},
+	},
+	{
+		version:     3,
+		description: "create deaths table",
+		statements: []string{`
+CREATE TABLE IF NOT EXISTS Deaths (
+    ID INTEGER PRIMARY KEY AUTOINCREMENT,
+    GroupID TEXT,
+    OpenID TEXT,
+    KillerID TEXT,
+    Length INTEGER,
+    Tick INTEGER,
+    Cause TEXT
+);`, `
+CREATE INDEX IF NOT EXISTS idx_death_group ON Deaths (GroupID, Tick);`,
+		},
+	},
+	{
+		version:     4,
+		description: "store games.LastRefresh as unix seconds",
+		// SQLite不能修改列类型，重建Games表，把文本时间转换为Unix时间戳
+		statements: []string{`
+CREATE TABLE Games_new (
+    GroupID TEXT PRIMARY KEY,
+    MapWidth INTEGER,
+    MapHeight INTEGER,
+    LastRefresh INTEGER,
+    RefreshInterval INTEGER,
+    Seed INTEGER DEFAULT 0,
+    Tick INTEGER DEFAULT 0
+);`, `
+INSERT INTO Games_new (GroupID, MapWidth, MapHeight, LastRefresh, RefreshInterval, Seed, Tick)
+SELECT GroupID, MapWidth, MapHeight,
+    CASE typeof(LastRefresh)
+        WHEN 'integer' THEN LastRefresh
+        WHEN 'real' THEN CAST(LastRefresh AS INTEGER)
+        WHEN 'text' THEN COALESCE(CAST(strftime('%s', substr(LastRefresh, 1, 19)) AS INTEGER), 0)
+        ELSE 0
+    END,
+    RefreshInterval, Seed, Tick
+FROM Games;`, `
+DROP TABLE Games;`, `
+ALTER TABLE Games_new RENAME TO Games;`,
+		},
+	},
+	{
+		version:     5,
+		description: "add background to games",
+		statements: []string{`
+ALTER TABLE Games ADD COLUMN Background TEXT DEFAULT '';`,
+		},
+	},
+	{
+		version:     6,
+		description: "create players table for nicknames",
+		statements: []string{`
+CREATE TABLE IF NOT EXISTS Players (
+    GroupID TEXT,
+    OpenID TEXT,
+    Nickname TEXT,
+    PRIMARY KEY (GroupID, OpenID)
+);`,
+		},
+	},
+	{
+		version:     7,
+		description: "add renderer to games",
+		statements: []string{`
+ALTER TABLE Games ADD COLUMN Renderer TEXT DEFAULT '';`,
+		},
+	},
+	{
+		version:     8,
+		description: "add theme to games",
+		statements: []string{`
+ALTER TABLE Games ADD COLUMN Theme TEXT DEFAULT '';`,
+		},
+	},
+	{
+		version:     9,
+		description: "add edge mode to games",
+		statements: []string{`
+ALTER TABLE Games ADD COLUMN Edges TEXT DEFAULT 'wrap';`,
+		},
+	},
+	{
+		version:     10,
+		description: "add level and tiles to games",
+		statements: []string{`
+ALTER TABLE Games ADD COLUMN Level TEXT DEFAULT '';`, `
+ALTER TABLE Games ADD COLUMN Tiles TEXT DEFAULT '';`,
+		},
+	},
+	{
+		version:     11,
+		description: "add score and food effects to snakes",
+		statements: []string{`
+ALTER TABLE Snakes ADD COLUMN Score INTEGER DEFAULT 0;`, `
+ALTER TABLE Snakes ADD COLUMN SpeedUp INTEGER DEFAULT 0;`, `
+ALTER TABLE Snakes ADD COLUMN Shield INTEGER DEFAULT 0;`, `
+ALTER TABLE Snakes ADD COLUMN Poison INTEGER DEFAULT 0;`,
+		},
+	},
+	{
+		version:     12,
+		description: "create inputs table for replays",
+		statements: []string{`
+CREATE TABLE IF NOT EXISTS Inputs (
+    ID INTEGER PRIMARY KEY AUTOINCREMENT,
+    GroupID TEXT,
+    Tick INTEGER,
+    Kind TEXT,
+    OpenID TEXT,
+    Direction TEXT,
+    Food TEXT
+);`, `
+CREATE INDEX IF NOT EXISTS idx_input_group ON Inputs (GroupID, Tick);`,
+		},
+	},
+}
+
+// LatestSchemaVersion 当前程序支持的最新结构版本
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaVersion 返回数据库当前的结构版本，没有版本记录时为0
+func SchemaVersion(db *sql.DB) (int, error) {
+	if _, err := db.Exec(createSchemaVersionTableSQL); err != nil {
+		return 0, err
+	}
+	var version int
+	err := db.QueryRow("SELECT COALESCE(MAX(Version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+// Migrate 依次执行尚未应用的迁移，每个迁移在独立的事务中执行并记录版本
+func Migrate(db *sql.DB) error {
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	latest := LatestSchemaVersion()
+	if current > latest {
+		return fmt.Errorf("database schema version %d is newer than supported version %d, please upgrade the program", current, latest)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		log.Printf("Migrating database to version %d: %s", m.version, m.description)
+		if err := runMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+	}
+	return nil
+}
+
+func runMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range m.statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if m.apply != nil {
+		if err := m.apply(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// 只保留一行版本记录
+	if _, err := tx.Exec("DELETE FROM schema_version"); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_version (Version) VALUES (?)", m.version); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// addColumnIfMissing 为表补充缺少的列，已经存在时不做任何修改
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	exists := false
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		if name == column {
+			exists = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	_, err = tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
@@ -0,0 +1,155 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// createLegacySchema 没有版本记录的旧数据库，LastRefresh为TIMESTAMP列
+func createLegacySchema(t *testing.T, db *sql.DB) {
+	t.Helper()
+	for _, stmt := range []string{
+		`CREATE TABLE Games (GroupID TEXT PRIMARY KEY, MapWidth INTEGER, MapHeight INTEGER, LastRefresh TIMESTAMP, RefreshInterval INTEGER);`,
+		`CREATE TABLE Snakes (GroupID TEXT, OpenID TEXT, Positions TEXT, Direction TEXT, PRIMARY KEY (GroupID, OpenID));`,
+		`CREATE TABLE Foods (ID INTEGER PRIMARY KEY AUTOINCREMENT, GroupID TEXT, Position TEXT);`,
+		// 旧版本的驱动把time.Time写成文本，也有直接写入Unix时间戳的
+		`INSERT INTO Games VALUES ('text', 20, 20, '2023-11-14 22:13:20.123456789+00:00', 5);`,
+		`INSERT INTO Games VALUES ('integer', 30, 10, 1700000000, 10);`,
+		`INSERT INTO Snakes VALUES ('text', 'alice', '[{"x":1,"y":2,"avatar":"alice_small.jpg"}]', 'up');`,
+		`INSERT INTO Foods (GroupID, Position) VALUES ('text', '{"x":3,"y":4,"avatar":"food_small.png"}');`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// dumpDatabase 数据库的结构和全部数据，用于比较两次迁移之间是否有变化
+func dumpDatabase(t *testing.T, db *sql.DB) string {
+	t.Helper()
+	var dump strings.Builder
+	rows, err := db.Query("SELECT type, name, COALESCE(sql, '') FROM sqlite_master ORDER BY type, name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tables []string
+	for rows.Next() {
+		var kind, name, stmt string
+		if err := rows.Scan(&kind, &name, &stmt); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&dump, "%s %s %s\n", kind, name, stmt)
+		if kind == "table" && !strings.HasPrefix(name, "sqlite_") {
+			tables = append(tables, name)
+		}
+	}
+	rows.Close()
+
+	for _, table := range tables {
+		rows, err := db.Query("SELECT * FROM " + table + " ORDER BY rowid")
+		if err != nil {
+			t.Fatal(err)
+		}
+		columns, _ := rows.Columns()
+		for rows.Next() {
+			values := make([]any, len(columns))
+			pointers := make([]any, len(columns))
+			for i := range values {
+				pointers[i] = &values[i]
+			}
+			if err := rows.Scan(pointers...); err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintf(&dump, "%s %v\n", table, values)
+		}
+		rows.Close()
+	}
+	return dump.String()
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	db := openTestDB(t)
+	createLegacySchema(t, db)
+
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := SchemaVersion(db); err != nil || version != LatestSchemaVersion() {
+		t.Fatalf("schema version %d (%v), want %d", version, err, LatestSchemaVersion())
+	}
+
+	// 文本和整数的时间都转换为Unix时间戳
+	for _, groupID := range []string{"text", "integer"} {
+		var kind string
+		var lastRefresh int64
+		if err := db.QueryRow("SELECT typeof(LastRefresh), LastRefresh FROM Games WHERE GroupID = ?", groupID).Scan(&kind, &lastRefresh); err != nil {
+			t.Fatal(err)
+		}
+		if kind != "integer" || lastRefresh != 1700000000 {
+			t.Errorf("%s: LastRefresh is %s %d, want integer 1700000000", groupID, kind, lastRefresh)
+		}
+	}
+
+	// 旧的数据可以按最新的结构载入
+	repo := NewRepository(db)
+	game, err := repo.Load("text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if game.Map.Width != 20 || game.RefreshInterval != 5 || game.Map.Edges != "wrap" || game.Seed != 0 || game.Tick != 0 {
+		t.Errorf("migrated game %+v", game)
+	}
+	if alice := game.Map.Snakes["alice"]; len(alice.Positions) != 1 || alice.Direction != "up" || alice.Score != 0 {
+		t.Errorf("migrated snake %+v", alice)
+	}
+	if len(game.Map.Food) != 1 || game.Map.Food[0].X != 3 {
+		t.Errorf("migrated food %+v", game.Map.Food)
+	}
+	if inputs, err := repo.Inputs("text"); err != nil || len(inputs) != 0 {
+		t.Errorf("inputs %v (%v)", inputs, err)
+	}
+
+	// 再次迁移不做任何修改
+	before := dumpDatabase(t, db)
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if after := dumpDatabase(t, db); after != before {
+		t.Errorf("second migration changed the database:\nbefore:\n%s\nafter:\n%s", before, after)
+	}
+}
+
+func TestMigrateNewDatabase(t *testing.T) {
+	db := openTestDB(t)
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := SchemaVersion(db); err != nil || version != LatestSchemaVersion() {
+		t.Fatalf("schema version %d (%v), want %d", version, err, LatestSchemaVersion())
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM schema_version"); n != 1 {
+		t.Errorf("%d schema_version rows, want 1", n)
+	}
+}
+
+func TestMigrateRefusesNewerDatabase(t *testing.T) {
+	db := openTestDB(t)
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	newer := LatestSchemaVersion() + 1
+	if _, err := db.Exec("UPDATE schema_version SET Version = ?", newer); err != nil {
+		t.Fatal(err)
+	}
+	before := dumpDatabase(t, db)
+
+	err := Migrate(db)
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("version %d is newer", newer)) {
+		t.Fatalf("error %v, want a newer schema error", err)
+	}
+	if after := dumpDatabase(t, db); after != before {
+		t.Error("refused migration changed the database")
+	}
+}
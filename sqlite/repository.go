@@ -0,0 +1,229 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/hoshinonyaruko/snake-in-im/repository"
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// Repository 基于SQLite的GameRepository实现
+type Repository struct {
+	db *sql.DB
+}
+
+// NewRepository 使用已经初始化的数据库创建存储
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+func (r *Repository) Load(groupID string) (*structs.Game, error) {
+	var game structs.Game
+	var tileData string
+
+	err := r.db.QueryRow("SELECT GroupID, MapWidth, MapHeight, LastRefresh, RefreshInterval, Seed, Tick, Background, Renderer, Theme, Edges, Level, Tiles FROM Games WHERE GroupID = ?", groupID).Scan(
+		&game.GroupID, &game.Map.Width, &game.Map.Height, &game.LastRefresh, &game.RefreshInterval, &game.Seed, &game.Tick, &game.Background, &game.Renderer, &game.Theme, &game.Map.Edges, &game.Level, &tileData,
+	)
+	if err == sql.ErrNoRows {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 关卡的格子在创建后不再变化，以JSON保存在Games表中
+	if tileData != "" {
+		if err := json.Unmarshal([]byte(tileData), &game.Map.Tiles); err != nil {
+			return nil, err
+		}
+	}
+
+	// Load snakes
+	rows, err := r.db.Query("SELECT OpenID, Positions, Direction, Score, SpeedUp, Shield, Poison FROM Snakes WHERE GroupID = ?", groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	game.Map.Snakes = make(map[string]structs.Snake)
+	var posData string
+	for rows.Next() {
+		var snake structs.Snake
+		// 注意，我们不再从数据库读取Avatar，因为每个Position已经包含Avatar
+		if err := rows.Scan(&snake.OpenID, &posData, &snake.Direction, &snake.Score, &snake.SpeedUp, &snake.Shield, &snake.Poison); err != nil {
+			return nil, err
+		}
+		// 反序列化Position数据，其中每个Position包含了Avatar信息
+		if err := json.Unmarshal([]byte(posData), &snake.Positions); err != nil {
+			return nil, err
+		}
+		game.Map.Snakes[snake.OpenID] = snake
+	}
+
+	// Load food position
+	var foodPositions []structs.Position
+	foodRows, err := r.db.Query("SELECT Position FROM Foods WHERE GroupID = ?", game.GroupID)
+	if err != nil {
+		return nil, err
+	}
+	defer foodRows.Close()
+
+	for foodRows.Next() {
+		var posData string
+		if err := foodRows.Scan(&posData); err != nil {
+			return nil, err
+		}
+		var pos structs.Position
+		if err := json.Unmarshal([]byte(posData), &pos); err != nil {
+			return nil, err
+		}
+		foodPositions = append(foodPositions, pos)
+	}
+	game.Map.Food = foodPositions
+
+	// Load nicknames
+	game.Nicknames = make(map[string]string)
+	playerRows, err := r.db.Query("SELECT OpenID, Nickname FROM Players WHERE GroupID = ?", groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer playerRows.Close()
+
+	for playerRows.Next() {
+		var openID, nickname string
+		if err := playerRows.Scan(&openID, &nickname); err != nil {
+			return nil, err
+		}
+		game.Nicknames[openID] = nickname
+	}
+
+	return &game, nil
+}
+
+func (r *Repository) Create(game *structs.Game) error {
+	var tileData string
+	if len(game.Map.Tiles) > 0 {
+		data, err := json.Marshal(game.Map.Tiles)
+		if err != nil {
+			return err
+		}
+		tileData = string(data)
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO Games (GroupID, MapWidth, MapHeight, LastRefresh, RefreshInterval, Seed, Tick, Background, Renderer, Theme, Edges, Level, Tiles) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		game.GroupID, game.Map.Width, game.Map.Height, game.LastRefresh, game.RefreshInterval, game.Seed, game.Tick, game.Background, game.Renderer, game.Theme, game.Map.Edges, game.Level, tileData)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	// 初始食物等创建时的输入
+	if err := insertInputs(tx, game.GroupID, game.Inputs); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	game.Inputs = nil
+	return nil
+}
+
+func (r *Repository) Save(game *structs.Game, deaths []structs.Death) error {
+	if err := UpdateGameMapInDB(r.db, game, deaths); err != nil {
+		return err
+	}
+	game.Inputs = nil
+	return nil
+}
+
+func (r *Repository) Delete(groupID string) error {
+	// 开始一个事务
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// 删除所有关联的记录，最后删除游戏记录
+	for _, stmt := range []string{
+		"DELETE FROM Snakes WHERE GroupID = ?",
+		"DELETE FROM Foods WHERE GroupID = ?",
+		"DELETE FROM Deaths WHERE GroupID = ?",
+		"DELETE FROM Players WHERE GroupID = ?",
+		"DELETE FROM Inputs WHERE GroupID = ?",
+		"DELETE FROM Games WHERE GroupID = ?",
+	} {
+		if _, err := tx.Exec(stmt, groupID); err != nil {
+			tx.Rollback() // 回滚事务
+			return err
+		}
+	}
+
+	// 提交事务
+	return tx.Commit()
+}
+
+func (r *Repository) SetDirection(groupID, openID, direction string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("UPDATE Snakes SET Direction = ? WHERE GroupID = ? AND OpenID = ?", direction, groupID, openID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// 确认是否确实更新了某条记录
+	count, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if count == 0 {
+		tx.Rollback()
+		return repository.ErrNotFound
+	}
+
+	// 新的方向从下一次刷新开始生效
+	var tick int64
+	if err := tx.QueryRow("SELECT Tick FROM Games WHERE GroupID = ?", groupID).Scan(&tick); err != nil {
+		tx.Rollback()
+		return err
+	}
+	input := structs.Input{Tick: tick, Kind: structs.InputDirection, OpenID: openID, Direction: direction}
+	if err := insertInputs(tx, groupID, []structs.Input{input}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *Repository) List() ([]string, error) {
+	rows, err := r.db.Query("SELECT GroupID FROM Games ORDER BY GroupID")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groupIDs := []string{}
+	for rows.Next() {
+		var groupID string
+		if err := rows.Scan(&groupID); err != nil {
+			return nil, err
+		}
+		groupIDs = append(groupIDs, groupID)
+	}
+	return groupIDs, rows.Err()
+}
+
+func (r *Repository) Deaths(groupID string, afterTick int64) ([]structs.Death, error) {
+	return GetDeaths(r.db, groupID, afterTick)
+}
+
+func (r *Repository) Inputs(groupID string) ([]structs.Input, error) {
+	return GetInputs(r.db, groupID)
+}
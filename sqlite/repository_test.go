@@ -0,0 +1,107 @@
+package sqlite
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/hoshinonyaruko/snake-in-im/structs"
+)
+
+// openTestDB 在临时目录中打开一个空的数据库文件
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "game.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func countRows(t *testing.T, db *sql.DB, query string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// TestSaveRemovesDeadSnakes 淘汰的蛇从Snakes表中删除并写入Deaths表，重新载入后不会复活
+func TestSaveRemovesDeadSnakes(t *testing.T) {
+	db := openTestDB(t)
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(db)
+
+	game := &structs.Game{
+		GroupID:         "group",
+		RefreshInterval: 5,
+		Seed:            42,
+		LastRefresh:     1700000000,
+		Map: structs.GameMap{
+			Width:  10,
+			Height: 10,
+			Edges:  "wrap",
+			Snakes: map[string]structs.Snake{
+				"alice": {OpenID: "alice", Direction: "right", Positions: []structs.Position{{X: 1, Y: 1, Avatar: "alice_small.jpg"}}},
+				"bob":   {OpenID: "bob", Direction: "left", Positions: []structs.Position{{X: 5, Y: 5, Avatar: "bob_small.jpg"}}},
+			},
+			Food: []structs.Position{},
+		},
+	}
+	if err := repo.Create(game); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Save(game, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM Snakes WHERE GroupID = ?", "group"); n != 2 {
+		t.Fatalf("%d snakes stored, want 2", n)
+	}
+
+	// bob被alice吃掉
+	delete(game.Map.Snakes, "bob")
+	game.Tick = 1
+	death := structs.Death{OpenID: "bob", KillerID: "alice", Length: 1, Tick: 1, Cause: "eaten"}
+	if err := repo.Save(game, []structs.Death{death}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := countRows(t, db, "SELECT COUNT(*) FROM Snakes WHERE GroupID = ? AND OpenID = ?", "group", "bob"); n != 0 {
+		t.Errorf("dead snake still has %d Snakes rows", n)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM Deaths WHERE GroupID = ? AND OpenID = ? AND KillerID = ? AND Tick = ? AND Cause = ?", "group", "bob", "alice", 1, "eaten"); n != 1 {
+		t.Errorf("%d Deaths rows for bob, want 1", n)
+	}
+
+	loaded, err := repo.Load("group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, alive := loaded.Map.Snakes["bob"]; alive {
+		t.Error("dead snake came back after reloading")
+	}
+	if _, alive := loaded.Map.Snakes["alice"]; !alive || len(loaded.Map.Snakes) != 1 {
+		t.Errorf("snakes after reloading: %v", loaded.Map.Snakes)
+	}
+
+	// 再次保存载入的游戏也不会让它复活
+	if err := repo.Save(loaded, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM Snakes WHERE GroupID = ?", "group"); n != 1 {
+		t.Errorf("%d snakes stored after saving again, want 1", n)
+	}
+	deaths, err := repo.Deaths("group", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deaths) != 1 || deaths[0] != death {
+		t.Errorf("deaths %+v, want %+v", deaths, death)
+	}
+}
@@ -8,53 +8,15 @@ import (
 	"github.com/hoshinonyaruko/snake-in-im/structs"
 )
 
-const createGamesTableSQL = `
-CREATE TABLE IF NOT EXISTS Games (
-    GroupID TEXT PRIMARY KEY,
-    MapWidth INTEGER,
-    MapHeight INTEGER,
-    LastRefresh TIMESTAMP,
-    RefreshInterval INTEGER
-);
-`
-
-const createSnakesTableSQL = `
-CREATE TABLE IF NOT EXISTS Snakes (
-    GroupID TEXT,
-    OpenID TEXT,
-    Positions TEXT,
-    Direction TEXT,
-    PRIMARY KEY (GroupID, OpenID)
-);
-`
-
-const createFoodsTableSQL = `
-CREATE TABLE IF NOT EXISTS Foods (
-    ID INTEGER PRIMARY KEY AUTOINCREMENT,
-    GroupID TEXT,
-    Position TEXT
-);
-`
-
-const createSnakesIndexSQL = `
-CREATE INDEX IF NOT EXISTS idx_snake_group ON Snakes (GroupID);
-`
-
-func executeSQL(db *sql.DB, sqlStatement string) {
-	_, err := db.Exec(sqlStatement)
-	if err != nil {
-		log.Fatalf("Error executing SQL statement: %s\n%s", sqlStatement, err)
-	}
-}
-
+// InitializeDatabase 将数据库升级到最新的结构版本，数据库版本比程序更新时拒绝启动
 func InitializeDatabase(db *sql.DB) {
-	executeSQL(db, createGamesTableSQL)
-	executeSQL(db, createSnakesTableSQL)
-	executeSQL(db, createFoodsTableSQL)
-	executeSQL(db, createSnakesIndexSQL)
+	if err := Migrate(db); err != nil {
+		log.Fatalf("Error migrating database: %s", err)
+	}
 }
 
-func UpdateGameMapInDB(db *sql.DB, game *structs.Game) error {
+// UpdateGameMapInDB 保存游戏状态，删除已经不在地图上的蛇并记录本次的淘汰事件
+func UpdateGameMapInDB(db *sql.DB, game *structs.Game, deaths []structs.Death) error {
 	// 开启事务
 	tx, err := db.Begin()
 	if err != nil {
@@ -62,8 +24,8 @@ func UpdateGameMapInDB(db *sql.DB, game *structs.Game) error {
 	}
 
 	// 更新游戏基本信息
-	_, err = tx.Exec("UPDATE Games SET MapWidth = ?, MapHeight = ?, LastRefresh = ?, RefreshInterval = ? WHERE GroupID = ?",
-		game.Map.Width, game.Map.Height, game.LastRefresh, game.RefreshInterval, game.GroupID)
+	_, err = tx.Exec("UPDATE Games SET MapWidth = ?, MapHeight = ?, LastRefresh = ?, RefreshInterval = ?, Seed = ?, Tick = ?, Background = ?, Renderer = ?, Theme = ? WHERE GroupID = ?",
+		game.Map.Width, game.Map.Height, game.LastRefresh, game.RefreshInterval, game.Seed, game.Tick, game.Background, game.Renderer, game.Theme, game.GroupID)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -90,6 +52,58 @@ func UpdateGameMapInDB(db *sql.DB, game *structs.Game) error {
 		}
 	}
 
+	// 保存玩家昵称
+	for openID, nickname := range game.Nicknames {
+		_, err = tx.Exec("INSERT OR REPLACE INTO Players (GroupID, OpenID, Nickname) VALUES (?, ?, ?)", game.GroupID, openID, nickname)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// 对比数据库中的蛇，删除已被淘汰的蛇，避免下次加载时复活
+	rows, err := tx.Query("SELECT OpenID FROM Snakes WHERE GroupID = ?", game.GroupID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	var removed []string
+	for rows.Next() {
+		var openID string
+		if err := rows.Scan(&openID); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return err
+		}
+		if _, alive := game.Map.Snakes[openID]; !alive {
+			removed = append(removed, openID)
+		}
+	}
+	rows.Close()
+	for _, openID := range removed {
+		_, err = tx.Exec("DELETE FROM Snakes WHERE GroupID = ? AND OpenID = ?", game.GroupID, openID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// 记录淘汰事件
+	for _, death := range deaths {
+		_, err = tx.Exec("INSERT INTO Deaths (GroupID, OpenID, KillerID, Length, Tick, Cause) VALUES (?, ?, ?, ?, ?, ?)",
+			game.GroupID, death.OpenID, death.KillerID, death.Length, death.Tick, death.Cause)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// 追加本次产生的输入记录
+	if err := insertInputs(tx, game.GroupID, game.Inputs); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	// 更新所有蛇的信息
 	for _, snake := range game.Map.Snakes {
 		positionsData, err := json.Marshal(snake.Positions)
@@ -97,8 +111,8 @@ func UpdateGameMapInDB(db *sql.DB, game *structs.Game) error {
 			tx.Rollback()
 			return err
 		}
-		_, err = tx.Exec("INSERT OR REPLACE INTO Snakes (GroupID, OpenID, Positions, Direction) VALUES (?, ?, ?, ?)",
-			game.GroupID, snake.OpenID, string(positionsData), snake.Direction)
+		_, err = tx.Exec("INSERT OR REPLACE INTO Snakes (GroupID, OpenID, Positions, Direction, Score, SpeedUp, Shield, Poison) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			game.GroupID, snake.OpenID, string(positionsData), snake.Direction, snake.Score, snake.SpeedUp, snake.Shield, snake.Poison)
 		if err != nil {
 			tx.Rollback()
 			return err
@@ -108,3 +122,73 @@ func UpdateGameMapInDB(db *sql.DB, game *structs.Game) error {
 	// 提交事务
 	return tx.Commit()
 }
+
+// GetDeaths 返回某个群组在指定刷新次数之后的淘汰事件，按发生顺序排列
+func GetDeaths(db *sql.DB, groupID string, afterTick int64) ([]structs.Death, error) {
+	rows, err := db.Query("SELECT OpenID, KillerID, Length, Tick, Cause FROM Deaths WHERE GroupID = ? AND Tick > ? ORDER BY Tick, ID", groupID, afterTick)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deaths := []structs.Death{}
+	for rows.Next() {
+		var death structs.Death
+		if err := rows.Scan(&death.OpenID, &death.KillerID, &death.Length, &death.Tick, &death.Cause); err != nil {
+			return nil, err
+		}
+		deaths = append(deaths, death)
+	}
+	return deaths, rows.Err()
+}
+
+// execer 事务和数据库共有的执行方法
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// insertInputs 按顺序追加输入记录，食物以JSON保存
+func insertInputs(db execer, groupID string, inputs []structs.Input) error {
+	for _, input := range inputs {
+		var foodData string
+		if input.Food != nil {
+			data, err := json.Marshal(input.Food)
+			if err != nil {
+				return err
+			}
+			foodData = string(data)
+		}
+		_, err := db.Exec("INSERT INTO Inputs (GroupID, Tick, Kind, OpenID, Direction, Food) VALUES (?, ?, ?, ?, ?, ?)",
+			groupID, input.Tick, input.Kind, input.OpenID, input.Direction, foodData)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetInputs 返回某个群组的输入记录，按产生顺序排列
+func GetInputs(db *sql.DB, groupID string) ([]structs.Input, error) {
+	rows, err := db.Query("SELECT Tick, Kind, OpenID, Direction, Food FROM Inputs WHERE GroupID = ? ORDER BY ID", groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	inputs := []structs.Input{}
+	for rows.Next() {
+		var input structs.Input
+		var foodData string
+		if err := rows.Scan(&input.Tick, &input.Kind, &input.OpenID, &input.Direction, &foodData); err != nil {
+			return nil, err
+		}
+		if foodData != "" {
+			input.Food = &structs.Position{}
+			if err := json.Unmarshal([]byte(foodData), input.Food); err != nil {
+				return nil, err
+			}
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs, rows.Err()
+}
@@ -0,0 +1,41 @@
+package structs
+
+// StateSchemaVersion GameState的JSON结构版本，字段只增不改，不兼容的修改需要增加版本号
+const StateSchemaVersion = 1
+
+// SnakeState 描述一条蛇在状态接口中的信息。
+type SnakeState struct {
+	OpenID    string     `json:"open_id"`   // 用户标识
+	Nickname  string     `json:"nickname"`  // 玩家昵称，未设置时为空
+	Direction string     `json:"direction"` // 移动方向
+	Length    int        `json:"length"`    // 蛇的长度
+	Kills     int        `json:"kills"`     // 吃掉其他蛇的次数
+	Score     int        `json:"score"`     // 吃食物获得的分数
+	SpeedUp   int        `json:"speed_up"`  // 加速剩余的刷新次数
+	Shield    int        `json:"shield"`    // 护盾剩余的刷新次数
+	Poison    int        `json:"poison"`    // 中毒剩余的刷新次数
+	Rank      int        `json:"rank"`      // 排名，从1开始
+	Head      Position   `json:"head"`      // 蛇头位置
+	Positions []Position `json:"positions"` // 蛇身上的每个格子的位置，第一个为蛇头
+}
+
+// GameState 描述一个游戏的完整状态，供机器人生成文字回复和排行榜。
+type GameState struct {
+	SchemaVersion   int          `json:"schema_version"`   // 结构版本，见StateSchemaVersion
+	GroupID         string       `json:"group_id"`         // 游戏组标识
+	Width           int          `json:"width"`            // 地图宽度
+	Height          int          `json:"height"`           // 地图高度
+	Tick            int64        `json:"tick"`             // 已执行的刷新次数
+	LastRefresh     int64        `json:"last_refresh"`     // 最后刷新时间，时间戳
+	RefreshInterval int          `json:"refresh_interval"` // 刷新间隔，单位秒
+	NextTickAt      int64        `json:"next_tick_at"`     // 下一次刷新的时间，时间戳
+	Background      string       `json:"background"`       // 地图背景
+	Renderer        string       `json:"renderer"`         // 群组默认的渲染器，为空时使用配置
+	Theme           string       `json:"theme"`            // 群组的主题，为空时使用配置
+	Edges           string       `json:"edges"`            // 边缘模式
+	Level           string       `json:"level"`            // 关卡名称，没有使用关卡时为空
+	Tiles           []Tile       `json:"tiles"`            // 关卡中的墙、障碍物和出生区域
+	Snakes          []SnakeState `json:"snakes"`           // 存活的蛇，按排名排列
+	Food            []Position   `json:"food"`             // 食物的位置
+	Deaths          []Death      `json:"deaths"`           // 本次请求中刷新产生的淘汰事件
+}
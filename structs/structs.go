@@ -2,9 +2,19 @@ package structs
 
 // Position 描述游戏地图上的一个坐标位置。
 type Position struct {
-	X      int    `json:"x"`      // X坐标
-	Y      int    `json:"y"`      // Y坐标
-	Avatar string `json:"avatar"` // 头像的本地路径，每个块可独立
+	X      int         `json:"x"`                // X坐标
+	Y      int         `json:"y"`                // Y坐标
+	Avatar string      `json:"avatar"`           // 头像的本地路径，每个块可独立
+	Food   string      `json:"food,omitempty"`   // 食物的种类，只用于地图上的食物，为空时由Avatar推断
+	Effect *FoodEffect `json:"effect,omitempty"` // 食物放置时的效果，只用于地图上的食物
+}
+
+// FoodEffect 食物放置时由食物清单决定的效果，之后修改清单不影响已经放置的食物
+type FoodEffect struct {
+	Growth   int    `json:"growth"`             // 吃掉后增加的节数，负数时减少
+	Score    int    `json:"score"`              // 吃掉后获得的分数
+	Effect   string `json:"effect,omitempty"`   // 效果，见food.EffectSpeedUp等
+	Duration int    `json:"duration,omitempty"` // 效果持续的刷新次数
 }
 
 // Snake 描述一条贪食蛇的信息。
@@ -12,6 +22,24 @@ type Snake struct {
 	Positions []Position `json:"positions"` // 蛇身上的每个格子的位置
 	OpenID    string     `json:"open_id"`   // 用户标识
 	Direction string     `json:"direction"` // 移动方向（"up", "down", "left", "right"）
+	Score     int        `json:"score"`     // 吃食物获得的分数
+	SpeedUp   int        `json:"speed_up"`  // 加速剩余的刷新次数
+	Shield    int        `json:"shield"`    // 护盾剩余的刷新次数
+	Poison    int        `json:"poison"`    // 中毒剩余的刷新次数
+}
+
+// 关卡格子的种类
+const (
+	TileWall     = "wall"     // 墙，撞上即淘汰
+	TileObstacle = "obstacle" // 障碍物，撞上即淘汰
+	TileSpawn    = "spawn"    // 出生区域，新蛇只在这里出现
+)
+
+// Tile 描述关卡中的一个格子，创建游戏时从关卡复制，之后不再改变。
+type Tile struct {
+	X    int    `json:"x"`    // X坐标
+	Y    int    `json:"y"`    // Y坐标
+	Kind string `json:"kind"` // 种类，见TileWall等
 }
 
 // GameMap 描述整个游戏地图的状态。
@@ -20,12 +48,48 @@ type GameMap struct {
 	Food   []Position       `json:"food"`   // 食物的位置，现在为数组
 	Width  int              `json:"width"`  // 地图宽度
 	Height int              `json:"height"` // 地图高度
+	Edges  string           `json:"edges"`  // 边缘模式（"wrap", "solid", "bounce"），为空时为wrap
+	Tiles  []Tile           `json:"tiles"`  // 关卡中的墙、障碍物和出生区域，没有关卡时为空
 }
 
 // Game 描述一个游戏实例，包括组ID和地图状态。
 type Game struct {
-	GroupID         string  `json:"group_id"`         // 游戏组标识
-	Map             GameMap `json:"map"`              // 游戏地图状态
-	LastRefresh     int64   `json:"last_refresh"`     // 最后刷新时间，时间戳
-	RefreshInterval int     `json:"refresh_interval"` // 刷新间隔，单位秒
+	GroupID         string            `json:"group_id"`         // 游戏组标识
+	Map             GameMap           `json:"map"`              // 游戏地图状态
+	LastRefresh     int64             `json:"last_refresh"`     // 最后刷新时间，时间戳
+	RefreshInterval int               `json:"refresh_interval"` // 刷新间隔，单位秒
+	Seed            int64             `json:"seed"`             // 随机数种子，配合Inputs表中的输入记录可复现对局
+	Tick            int64             `json:"tick"`             // 已执行的刷新次数
+	Background      string            `json:"background"`       // 地图背景（"avatar:<openid>", "image:<name>", "color:#rrggbb"）
+	Nicknames       map[string]string `json:"nicknames"`        // 以OpenID为key的玩家昵称，蛇被淘汰后仍然保留
+	Renderer        string            `json:"renderer"`         // 群组默认的渲染器，为空时使用配置
+	Theme           string            `json:"theme"`            // 群组的主题，为空时使用配置
+	Level           string            `json:"level"`            // 创建时使用的关卡，为空时为空白地图
+	Inputs          []Input           `json:"-"`                // 载入之后新产生的输入，保存时追加到输入记录
+}
+
+// 输入记录的种类
+const (
+	InputJoin      = "join"      // 玩家加入，新蛇的位置和方向由种子决定
+	InputDirection = "direction" // 玩家修改方向
+	InputFood      = "food"      // 放置食物，记录食物的位置和种类
+)
+
+// Input 描述一次改变游戏状态的输入，在第Tick次刷新之后、下一次刷新之前生效。
+// 种子、创建时的地图和按顺序排列的输入记录可以完整复现一局游戏。
+type Input struct {
+	Tick      int64     `json:"tick"`                // 生效前已执行的刷新次数
+	Kind      string    `json:"kind"`                // 种类，见InputJoin等
+	OpenID    string    `json:"open_id,omitempty"`   // 加入或修改方向的玩家
+	Direction string    `json:"direction,omitempty"` // 修改后的方向
+	Food      *Position `json:"food,omitempty"`      // 放置的食物
+}
+
+// Death 描述一条蛇在某次刷新中被淘汰的经过。
+type Death struct {
+	OpenID   string `json:"open_id"`   // 被淘汰的蛇
+	KillerID string `json:"killer_id"` // 吃掉它的蛇，自己撞死或平局时为空
+	Length   int    `json:"length"`    // 被淘汰时的长度
+	Tick     int64  `json:"tick"`      // 发生在第几次刷新
+	Cause    string `json:"cause"`     // 淘汰原因（"self", "head_on", "eaten", "wall", "obstacle", "poison"）
 }
@@ -0,0 +1,354 @@
+package theme
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/disintegration/imaging"
+	"github.com/golang/freetype/truetype"
+	"github.com/hajimehoshi/bitmapfont/v3"
+	"golang.org/x/image/font"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultName 内置主题的名称，themes目录中同名的主题会替换内置主题
+const DefaultName = "default"
+
+// 网格的样式
+const (
+	GridSolid  = "solid"  // 实线
+	GridDashed = "dashed" // 虚线
+	GridDotted = "dotted" // 只在格子的交点画点
+	GridNone   = "none"   // 不画网格
+)
+
+// manifestNames 主题目录中描述文件的名称，按顺序查找
+var manifestNames = []string{"theme.json", "theme.yaml", "theme.yml"}
+
+// spriteParts 精灵图中的部件数量，与api中的精灵图布局一致
+const spriteParts = 4
+
+// Grid 网格的外观
+type Grid struct {
+	Color color.NRGBA
+	Width float64
+	Style string
+}
+
+// Scoreboard 排行榜的颜色
+type Scoreboard struct {
+	Background color.NRGBA
+	Title      color.NRGBA
+	Text       color.NRGBA
+}
+
+// Theme 一套棋盘外观，载入后只读，可以被多个渲染同时使用
+type Theme struct {
+	Name       string
+	Version    uint64        // 每次载入都不同，用于区分缓存
+	Grid       Grid          // 网格
+	Missing    color.NRGBA   // 头像或食物图片缺失时的方块
+	Arrow      color.NRGBA   // 蛇头的方向箭头
+	Wall       color.NRGBA   // 地图边缘不相连时的墙，也用于关卡中的墙
+	Obstacle   color.NRGBA   // 关卡中的障碍物
+	Highlight  color.NRGBA   // 观看者的光晕、描边和"你"标记
+	Palette    []color.NRGBA // 精灵图画法中蛇的颜色
+	Scoreboard Scoreboard    // 排行榜
+
+	// 主题的背景，设置后替换群组的背景，两者都为空时使用群组的背景
+	BackgroundColor *color.NRGBA
+	BackgroundImage image.Image
+
+	// 蛇的精灵图，为空时使用全局的精灵图
+	Sprites image.Image
+
+	font     *truetype.Font
+	fontSize float64
+}
+
+// HasBackground 主题是否自带背景
+func (t *Theme) HasBackground() bool {
+	return t.BackgroundColor != nil || t.BackgroundImage != nil
+}
+
+// FontFace 返回绘制文字使用的字体，没有设置字体时使用内嵌的点阵字体
+// TrueType字体不能并发使用，每次调用都返回新的实例
+func (t *Theme) FontFace() font.Face {
+	if t.font == nil {
+		return bitmapfont.FaceSC
+	}
+	return truetype.NewFace(t.font, &truetype.Options{Size: t.fontSize})
+}
+
+// builtin 内置主题，与加入主题之前的外观相同
+var builtin = &Theme{
+	Name:      DefaultName,
+	Grid:      Grid{Color: color.NRGBA{230, 230, 230, 255}, Width: 1, Style: GridSolid},
+	Missing:   color.NRGBA{0, 0, 0, 255},
+	Arrow:     color.NRGBA{173, 217, 255, 255},
+	Wall:      color.NRGBA{68, 68, 68, 255},
+	Obstacle:  color.NRGBA{139, 94, 60, 255},
+	Highlight: color.NRGBA{255, 214, 0, 255},
+	Palette: []color.NRGBA{
+		{231, 76, 60, 255},
+		{52, 152, 219, 255},
+		{46, 204, 113, 255},
+		{230, 126, 34, 255},
+		{155, 89, 182, 255},
+		{26, 188, 156, 255},
+		{241, 196, 15, 255},
+		{236, 112, 160, 255},
+	},
+	Scoreboard: Scoreboard{
+		Background: color.NRGBA{38, 38, 38, 255},
+		Title:      color.NRGBA{255, 217, 77, 255},
+		Text:       color.NRGBA{255, 255, 255, 255},
+	},
+}
+
+var (
+	themesMutex sync.RWMutex
+	themes      = make(map[string]*Theme)
+	versions    uint64
+)
+
+// Default 返回默认主题，themes目录中有default主题时使用它
+func Default() *Theme {
+	if t, ok := Get(DefaultName); ok {
+		return t
+	}
+	return builtin
+}
+
+// Get 按名称查找主题，名称为空时返回默认主题
+func Get(name string) (*Theme, bool) {
+	if name == "" {
+		name = DefaultName
+	}
+	themesMutex.RLock()
+	t, ok := themes[name]
+	themesMutex.RUnlock()
+	if !ok && name == DefaultName {
+		return builtin, true
+	}
+	return t, ok
+}
+
+// Names 返回可用的主题名称
+func Names() []string {
+	themesMutex.RLock()
+	names := []string{DefaultName}
+	for name := range themes {
+		if name != DefaultName {
+			names = append(names, name)
+		}
+	}
+	themesMutex.RUnlock()
+	sort.Strings(names[1:])
+	return names
+}
+
+// LoadThemes 载入目录中的所有主题，每个子目录是一个主题，目录名即主题名
+// 无效的主题记录日志后跳过，不影响其他主题
+func LoadThemes(directory string) error {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return err
+	}
+	loaded := make(map[string]*Theme)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		t, err := LoadTheme(filepath.Join(directory, entry.Name()))
+		if err != nil {
+			log.Printf("Failed to load theme %s: %v", entry.Name(), err)
+			continue
+		}
+		loaded[t.Name] = t
+	}
+	themesMutex.Lock()
+	themes = loaded
+	themesMutex.Unlock()
+	return nil
+}
+
+// manifest 主题描述文件，未设置的项使用内置主题的值
+// 颜色写作#rgb、#rrggbb或#rrggbbaa，文件路径相对于主题目录
+type manifest struct {
+	Grid struct {
+		Color string  `json:"color" yaml:"color"`
+		Width float64 `json:"width" yaml:"width"`
+		Style string  `json:"style" yaml:"style"`
+	} `json:"grid" yaml:"grid"`
+	Background string   `json:"background" yaml:"background"` // 颜色或图片文件
+	Missing    string   `json:"missing" yaml:"missing"`
+	Arrow      string   `json:"arrow" yaml:"arrow"`
+	Wall       string   `json:"wall" yaml:"wall"`
+	Obstacle   string   `json:"obstacle" yaml:"obstacle"`
+	Highlight  string   `json:"highlight" yaml:"highlight"`
+	Palette    []string `json:"palette" yaml:"palette"`
+	Scoreboard struct {
+		Background string `json:"background" yaml:"background"`
+		Title      string `json:"title" yaml:"title"`
+		Text       string `json:"text" yaml:"text"`
+	} `json:"scoreboard" yaml:"scoreboard"`
+	Sprites string `json:"sprites" yaml:"sprites"` // 精灵图文件
+	Font    struct {
+		File string  `json:"file" yaml:"file"` // TrueType字体文件
+		Size float64 `json:"size" yaml:"size"`
+	} `json:"font" yaml:"font"`
+}
+
+// LoadTheme 从目录载入一个主题，主题名为目录名
+func LoadTheme(dir string) (*Theme, error) {
+	var m manifest
+	found := false
+	for _, name := range manifestNames {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if filepath.Ext(name) == ".json" {
+			err = json.Unmarshal(data, &m)
+		} else {
+			err = yaml.Unmarshal(data, &m)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		found = true
+		break
+	}
+	if !found {
+		return nil, fmt.Errorf("missing %s", strings.Join(manifestNames, " or "))
+	}
+
+	t := *builtin
+	t.Name = filepath.Base(dir)
+	t.Version = atomic.AddUint64(&versions, 1)
+
+	colors := []struct {
+		value string
+		dst   *color.NRGBA
+	}{
+		{m.Grid.Color, &t.Grid.Color},
+		{m.Missing, &t.Missing},
+		{m.Arrow, &t.Arrow},
+		{m.Wall, &t.Wall},
+		{m.Obstacle, &t.Obstacle},
+		{m.Highlight, &t.Highlight},
+		{m.Scoreboard.Background, &t.Scoreboard.Background},
+		{m.Scoreboard.Title, &t.Scoreboard.Title},
+		{m.Scoreboard.Text, &t.Scoreboard.Text},
+	}
+	for _, c := range colors {
+		if c.value == "" {
+			continue
+		}
+		parsed, err := ParseColor(c.value)
+		if err != nil {
+			return nil, err
+		}
+		*c.dst = parsed
+	}
+
+	if m.Grid.Width < 0 {
+		return nil, fmt.Errorf("invalid grid width %g", m.Grid.Width)
+	}
+	if m.Grid.Width > 0 {
+		t.Grid.Width = m.Grid.Width
+	}
+	switch m.Grid.Style {
+	case "":
+	case GridSolid, GridDashed, GridDotted, GridNone:
+		t.Grid.Style = m.Grid.Style
+	default:
+		return nil, fmt.Errorf("unsupported grid style %q", m.Grid.Style)
+	}
+
+	if len(m.Palette) > 0 {
+		t.Palette = make([]color.NRGBA, len(m.Palette))
+		for i, value := range m.Palette {
+			parsed, err := ParseColor(value)
+			if err != nil {
+				return nil, err
+			}
+			t.Palette[i] = parsed
+		}
+	}
+
+	// 背景以#开头时为颜色，否则为主题目录中的图片
+	if strings.HasPrefix(m.Background, "#") {
+		parsed, err := ParseColor(m.Background)
+		if err != nil {
+			return nil, err
+		}
+		t.BackgroundColor = &parsed
+	} else if m.Background != "" {
+		img, err := imaging.Open(filepath.Join(dir, m.Background))
+		if err != nil {
+			return nil, fmt.Errorf("background: %v", err)
+		}
+		t.BackgroundImage = img
+	}
+
+	if m.Sprites != "" {
+		img, err := imaging.Open(filepath.Join(dir, m.Sprites))
+		if err != nil {
+			return nil, fmt.Errorf("sprites: %v", err)
+		}
+		if cell := img.Bounds().Dy(); cell == 0 || img.Bounds().Dx() < cell*spriteParts {
+			return nil, fmt.Errorf("sprites: sheet must contain %d square parts side by side", spriteParts)
+		}
+		t.Sprites = img
+	}
+
+	if m.Font.File != "" {
+		data, err := os.ReadFile(filepath.Join(dir, m.Font.File))
+		if err != nil {
+			return nil, fmt.Errorf("font: %v", err)
+		}
+		t.font, err = truetype.Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("font: %v", err)
+		}
+		t.fontSize = m.Font.Size
+		if t.fontSize <= 0 {
+			t.fontSize = 12
+		}
+	}
+	return &t, nil
+}
+
+// ParseColor 解析#rgb、#rrggbb或#rrggbbaa格式的颜色
+func ParseColor(value string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q", value)
+	}
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q", value)
+	}
+	return color.NRGBA{R: uint8(n >> 24), G: uint8(n >> 16), B: uint8(n >> 8), A: uint8(n)}, nil
+}
@@ -0,0 +1,89 @@
+package theme
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchThemes 热更新主题，主题目录中的文件变化时重新载入该主题，新建的主题目录也会被监视
+// 载入失败时保留之前的版本，onChange在主题更新或删除后以主题名称调用
+func WatchThemes(directory string, onChange func(name string)) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		panic(err)
+	}
+	defer watcher.Close()
+
+	// fsnotify不会监视子目录，每个主题目录单独添加
+	if err := watcher.Add(directory); err != nil {
+		panic(err)
+	}
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		panic(err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if err := watcher.Add(filepath.Join(directory, entry.Name())); err != nil {
+				fmt.Println("error:", err)
+			}
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			rel, err := filepath.Rel(directory, event.Name)
+			if err != nil || rel == "." {
+				continue
+			}
+			name := strings.Split(filepath.ToSlash(rel), "/")[0]
+			dir := filepath.Join(directory, name)
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				// 主题目录被删除或改名
+				if remove(name) && onChange != nil {
+					onChange(name)
+				}
+				continue
+			}
+			if event.Name == dir && event.Op&fsnotify.Create == fsnotify.Create {
+				if err := watcher.Add(dir); err != nil {
+					fmt.Println("error:", err)
+				}
+			}
+			t, err := LoadTheme(dir)
+			if err != nil {
+				log.Printf("Failed to reload theme %s, keeping the previous version: %v", name, err)
+				continue
+			}
+			themesMutex.Lock()
+			themes[name] = t
+			themesMutex.Unlock()
+			if onChange != nil {
+				onChange(name)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			fmt.Println("error:", err)
+		}
+	}
+}
+
+// remove 删除已载入的主题，返回主题是否存在
+func remove(name string) bool {
+	themesMutex.Lock()
+	defer themesMutex.Unlock()
+	_, ok := themes[name]
+	delete(themes, name)
+	return ok
+}